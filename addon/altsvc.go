@@ -0,0 +1,15 @@
+package addon
+
+import "github.com/lqqyt2423/go-mitmproxy/proxy"
+
+// remove Alt-Svc header from responses, so that clients won't switch to HTTP/3 (QUIC) which bypasses the proxy
+
+type StripAltSvc struct {
+	proxy.BaseAddon
+}
+
+func (s *StripAltSvc) Responseheaders(f *proxy.Flow) {
+	if f.Response.Header != nil {
+		f.Response.Header.Del("Alt-Svc")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/lqqyt2423/go-mitmproxy/proxy"
@@ -87,6 +88,7 @@ func (d *Dumper) dump(f *proxy.Flow) {
 		}
 	}
 
+	dumpTiming(buf, f.Timing)
 	buf.WriteString("\r\n\r\n")
 
 	_, err = d.out.Write(buf.Bytes())
@@ -95,6 +97,33 @@ func (d *Dumper) dump(f *proxy.Flow) {
 	}
 }
 
+// durations since client connected, skip which not happened
+func dumpTiming(buf *bytes.Buffer, timing *proxy.Timing) {
+	if timing == nil || timing.ClientConnect.IsZero() {
+		return
+	}
+	items := []struct {
+		name string
+		t    time.Time
+	}{
+		{"request_headers", timing.RequestHeaders},
+		{"dns", timing.DnsDone},
+		{"connect", timing.ConnectDone},
+		{"tls", timing.TlsHandshakeDone},
+		{"request_sent", timing.RequestSent},
+		{"first_byte", timing.FirstResponseByte},
+		{"complete", timing.ResponseComplete},
+	}
+	buf.WriteString("Timing:")
+	for _, item := range items {
+		if item.t.IsZero() {
+			continue
+		}
+		fmt.Fprintf(buf, " %s=%v", item.name, item.t.Sub(timing.ClientConnect))
+	}
+	buf.WriteString("\r\n")
+}
+
 func canPrint(content []byte) bool {
 	for _, c := range string(content) {
 		if !unicode.IsPrint(c) && !unicode.IsSpace(c) {
@@ -0,0 +1,509 @@
+package addon
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+	log "github.com/sirupsen/logrus"
+)
+
+// export finished flows to HTTP Archive 1.2 file
+// http://www.softwareishard.com/blog/har-12-spec/
+
+type harLog struct {
+	Version string        `json:"version"`
+	Creator *harCreator   `json:"creator"`
+	Pages   []interface{} `json:"pages"`
+	Entries []*harEntry   `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime   string                 `json:"startedDateTime"`
+	Time              float64                `json:"time"`
+	Request           *harRequest            `json:"request"`
+	Response          *harResponse           `json:"response"`
+	Cache             struct{}               `json:"cache"`
+	Timings           *harTimings            `json:"timings"`
+	ServerIPAddress   string                 `json:"serverIPAddress,omitempty"`
+	Connection        string                 `json:"connection,omitempty"`
+	WebSocketMessages []*harWebSocketMessage `json:"_webSocketMessages,omitempty"`
+
+	startedTime time.Time
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harCookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Path     string `json:"path,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	Expires  string `json:"expires,omitempty"`
+	HttpOnly bool   `json:"httpOnly,omitempty"`
+	Secure   bool   `json:"secure,omitempty"`
+}
+
+type harRequest struct {
+	Method      string          `json:"method"`
+	URL         string          `json:"url"`
+	HttpVersion string          `json:"httpVersion"`
+	Cookies     []*harCookie    `json:"cookies"`
+	Headers     []*harNameValue `json:"headers"`
+	QueryString []*harNameValue `json:"queryString"`
+	PostData    *harPostData    `json:"postData,omitempty"`
+	HeadersSize int             `json:"headersSize"`
+	BodySize    int             `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harResponse struct {
+	Status      int             `json:"status"`
+	StatusText  string          `json:"statusText"`
+	HttpVersion string          `json:"httpVersion"`
+	Cookies     []*harCookie    `json:"cookies"`
+	Headers     []*harNameValue `json:"headers"`
+	Content     *harContent     `json:"content"`
+	RedirectURL string          `json:"redirectURL"`
+	HeadersSize int             `json:"headersSize"`
+	BodySize    int             `json:"bodySize"`
+	Error       string          `json:"_error,omitempty"`
+}
+
+type harContent struct {
+	Size        int    `json:"size"`
+	Compression int    `json:"compression,omitempty"`
+	MimeType    string `json:"mimeType"`
+	Text        string `json:"text,omitempty"`
+	Encoding    string `json:"encoding,omitempty"`
+}
+
+// milliseconds, -1 if not applicable, e.g. the server connection is reused
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+type harWebSocketMessage struct {
+	Type   string  `json:"type"` // send or receive
+	Time   float64 `json:"time"` // unix seconds
+	Opcode int     `json:"opcode"`
+	Data   string  `json:"data"` // base64 encoded if not text
+}
+
+type HarExporter struct {
+	proxy.BaseAddon
+	filename string
+	version  string // go-mitmproxy version
+
+	mu       sync.Mutex
+	entries  []*harEntry
+	streamed map[*proxy.Flow]struct{} // flows may have no Response hook, added when done
+}
+
+func NewHarExporter(filename string, version string) *HarExporter {
+	return &HarExporter{
+		filename: filename,
+		version:  version,
+		entries:  make([]*harEntry, 0),
+		streamed: make(map[*proxy.Flow]struct{}),
+	}
+}
+
+// Responseheaders streamed response is not buffered and has no Response hook,
+// add the entry without body after the flow finished
+func (h *HarExporter) Responseheaders(f *proxy.Flow) {
+	done := f.Done()
+	if done == nil {
+		return
+	}
+	h.mu.Lock()
+	h.streamed[f] = struct{}{}
+	h.mu.Unlock()
+
+	go func() {
+		<-done
+		h.mu.Lock()
+		_, pending := h.streamed[f]
+		delete(h.streamed, f)
+		h.mu.Unlock()
+		if pending && f.Stream {
+			h.add(f, nil)
+		}
+	}()
+}
+
+func (h *HarExporter) Response(f *proxy.Flow) {
+	h.add(f, nil)
+}
+
+func (h *HarExporter) RequestError(f *proxy.Flow, err error) {
+	h.add(f, err)
+}
+
+func (h *HarExporter) SSEEnd(f *proxy.Flow) {
+	h.add(f, nil)
+}
+
+func (h *HarExporter) WebSocketEnd(f *proxy.Flow) {
+	h.add(f, nil)
+}
+
+func (h *HarExporter) add(f *proxy.Flow, err error) {
+	if f.Request == nil {
+		return
+	}
+	entry := newHarEntry(f, err)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.streamed, f)
+	h.entries = append(h.entries, entry)
+}
+
+// Flush write all collected flows to the file
+func (h *HarExporter) Flush() error {
+	h.mu.Lock()
+	entries := make([]*harEntry, len(h.entries))
+	copy(entries, h.entries)
+	h.mu.Unlock()
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].startedTime.Before(entries[j].startedTime)
+	})
+	data, err := json.MarshalIndent(map[string]*harLog{
+		"log": {
+			Version: "1.2",
+			Creator: &harCreator{Name: "go-mitmproxy", Version: h.version},
+			Pages:   []interface{}{},
+			Entries: entries,
+		},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(h.filename, data, 0666)
+}
+
+func newHarEntry(f *proxy.Flow, err error) *harEntry {
+	startedTime := f.StartTime
+	if f.Timing != nil && !f.Timing.RequestHeaders.IsZero() {
+		startedTime = f.Timing.RequestHeaders
+	}
+	entry := &harEntry{
+		StartedDateTime: startedTime.Format(time.RFC3339Nano),
+		Request:         newHarRequest(f),
+		Response:        newHarResponse(f, err),
+		Timings:         newHarTimings(f.Timing),
+		startedTime:     startedTime,
+	}
+	for _, t := range []float64{entry.Timings.Blocked, entry.Timings.DNS, entry.Timings.Connect, entry.Timings.Send, entry.Timings.Wait, entry.Timings.Receive} {
+		if t > 0 {
+			entry.Time += t
+		}
+	}
+
+	if f.ConnContext != nil {
+		entry.Connection = f.ConnContext.Id().String()
+		if serverConn := f.ConnContext.ServerConn; serverConn != nil && serverConn.Conn != nil {
+			if host, _, err := net.SplitHostPort(serverConn.Conn.RemoteAddr().String()); err == nil {
+				entry.ServerIPAddress = host
+			}
+		}
+	}
+
+	if f.WebScoket != nil {
+		for _, msg := range f.WebScoket.Messages {
+			typ := "receive"
+			if msg.FromClient {
+				typ = "send"
+			}
+			data := string(msg.Content)
+			if msg.Type != websocket.TextMessage {
+				data = base64.StdEncoding.EncodeToString(msg.Content)
+			}
+			entry.WebSocketMessages = append(entry.WebSocketMessages, &harWebSocketMessage{
+				Type:   typ,
+				Time:   float64(msg.Timestamp.UnixMicro()) / 1e6,
+				Opcode: msg.Type,
+				Data:   data,
+			})
+		}
+	}
+
+	return entry
+}
+
+func newHarRequest(f *proxy.Flow) *harRequest {
+	req := f.Request
+	r := &harRequest{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		HttpVersion: req.Proto,
+		Cookies:     make([]*harCookie, 0),
+		Headers:     harHeaders(req.Header),
+		QueryString: make([]*harNameValue, 0),
+		HeadersSize: -1,
+		BodySize:    len(req.Body),
+	}
+	for _, c := range (&http.Request{Header: req.Header}).Cookies() {
+		r.Cookies = append(r.Cookies, &harCookie{Name: c.Name, Value: c.Value})
+	}
+	for name, values := range req.URL.Query() {
+		for _, v := range values {
+			r.QueryString = append(r.QueryString, &harNameValue{Name: name, Value: v})
+		}
+	}
+	sort.SliceStable(r.QueryString, func(i, j int) bool { return r.QueryString[i].Name < r.QueryString[j].Name })
+
+	if len(req.Body) > 0 {
+		body, err := req.DecodedBody()
+		if err != nil {
+			body = req.Body
+		}
+		text, encoding := harText(body)
+		r.PostData = &harPostData{
+			MimeType: req.Header.Get("Content-Type"),
+			Text:     text,
+			Encoding: encoding,
+		}
+	} else if f.Stream {
+		r.BodySize = -1
+	}
+	return r
+}
+
+func newHarResponse(f *proxy.Flow, err error) *harResponse {
+	r := &harResponse{
+		HttpVersion: f.Request.Proto,
+		Cookies:     make([]*harCookie, 0),
+		Headers:     make([]*harNameValue, 0),
+		Content:     &harContent{},
+		HeadersSize: -1,
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	res := f.Response
+	if res == nil {
+		if f.WebScoket != nil {
+			r.Status = http.StatusSwitchingProtocols
+			r.StatusText = http.StatusText(r.Status)
+		}
+		return r
+	}
+
+	r.Status = res.StatusCode
+	r.StatusText = http.StatusText(res.StatusCode)
+	r.Headers = harHeaders(res.Header)
+	r.RedirectURL = res.Header.Get("Location")
+	r.Content.MimeType = res.Header.Get("Content-Type")
+	for _, c := range (&http.Response{Header: res.Header}).Cookies() {
+		cookie := &harCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			HttpOnly: c.HttpOnly,
+			Secure:   c.Secure,
+		}
+		if !c.Expires.IsZero() {
+			cookie.Expires = c.Expires.Format(time.RFC3339)
+		}
+		r.Cookies = append(r.Cookies, cookie)
+	}
+
+	if res.Body == nil {
+		// streamed body is not buffered
+		r.BodySize = -1
+		return r
+	}
+	r.BodySize = len(res.Body)
+	body, decodeErr := res.DecodedBody()
+	if decodeErr != nil {
+		log.Debugf("har decode response body of %v error: %v", f.Request.URL, decodeErr)
+		body = res.Body
+	}
+	r.Content.Size = len(body)
+	r.Content.Compression = len(body) - len(res.Body)
+	r.Content.Text, r.Content.Encoding = harText(body)
+	return r
+}
+
+func newHarTimings(timing *proxy.Timing) *harTimings {
+	t := &harTimings{Blocked: -1, DNS: -1, Connect: -1, Send: -1, Wait: -1, Receive: -1, SSL: -1}
+	if timing == nil || timing.RequestHeaders.IsZero() {
+		return t
+	}
+
+	ms := func(start, end time.Time) float64 {
+		return float64(end.Sub(start).Microseconds()) / 1000
+	}
+	// the server connection established before this request is reused
+	last := timing.RequestHeaders
+	if timing.DnsDone.After(last) {
+		t.DNS = ms(last, timing.DnsDone)
+		last = timing.DnsDone
+	}
+	if timing.ConnectDone.After(last) {
+		connectStart := last
+		end := timing.ConnectDone
+		if timing.TlsHandshakeDone.After(end) {
+			t.SSL = ms(end, timing.TlsHandshakeDone)
+			end = timing.TlsHandshakeDone
+		}
+		// ssl is included in connect
+		t.Connect = ms(connectStart, end)
+		last = end
+	}
+	if !timing.RequestSent.IsZero() {
+		t.Send = ms(last, timing.RequestSent)
+		last = timing.RequestSent
+	}
+	if !timing.FirstResponseByte.IsZero() {
+		t.Wait = ms(last, timing.FirstResponseByte)
+		last = timing.FirstResponseByte
+	}
+	if !timing.ResponseComplete.IsZero() {
+		t.Receive = ms(last, timing.ResponseComplete)
+	}
+	if t.Send < 0 {
+		t.Send = 0
+	}
+	if t.Wait < 0 {
+		t.Wait = 0
+	}
+	if t.Receive < 0 {
+		t.Receive = 0
+	}
+	return t
+}
+
+func harHeaders(header http.Header) []*harNameValue {
+	headers := make([]*harNameValue, 0)
+	for name, values := range header {
+		for _, v := range values {
+			headers = append(headers, &harNameValue{Name: name, Value: v})
+		}
+	}
+	sort.SliceStable(headers, func(i, j int) bool { return headers[i].Name < headers[j].Name })
+	return headers
+}
+
+// text of body, base64 encoded if not utf8
+func harText(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func readHarFile(filename string) (*harLog, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var har struct {
+		Log *harLog `json:"log"`
+	}
+	if err := json.Unmarshal(data, &har); err != nil {
+		return nil, err
+	}
+	if har.Log == nil {
+		return nil, fmt.Errorf("%v is not a har file", filename)
+	}
+	return har.Log, nil
+}
+
+// HarRecord request recorded in har file, and when it was sent
+type HarRecord struct {
+	Request     *proxy.Request
+	StartedTime time.Time
+}
+
+// ReadHarRecords read requests from har file for client replay
+func ReadHarRecords(filename string) ([]*HarRecord, error) {
+	harLog, err := readHarFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*HarRecord, 0, len(harLog.Entries))
+	for _, entry := range harLog.Entries {
+		if entry.Request == nil {
+			continue
+		}
+		u, err := url.Parse(entry.Request.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid url %v: %w", entry.Request.URL, err)
+		}
+		startedTime, err := time.Parse(time.RFC3339Nano, entry.StartedDateTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid startedDateTime %v: %w", entry.StartedDateTime, err)
+		}
+
+		header := make(http.Header)
+		for _, h := range entry.Request.Headers {
+			// http2 pseudo headers recorded by browsers
+			if strings.HasPrefix(h.Name, ":") {
+				continue
+			}
+			header.Add(h.Name, h.Value)
+		}
+		var body []byte
+		if entry.Request.PostData != nil {
+			body, err = harDecodeText(entry.Request.PostData.Text, entry.Request.PostData.Encoding)
+			if err != nil {
+				return nil, err
+			}
+			// body in har is decoded, and the length is recalculated
+			header.Del("Content-Encoding")
+		}
+		header.Del("Content-Length")
+
+		proto := entry.Request.HttpVersion
+		if !strings.HasPrefix(proto, "HTTP/") {
+			proto = "HTTP/1.1"
+		}
+		records = append(records, &HarRecord{
+			Request: &proxy.Request{
+				Method: entry.Request.Method,
+				URL:    u,
+				Proto:  proto,
+				Header: header,
+				Body:   body,
+			},
+			StartedTime: startedTime,
+		})
+	}
+	return records, nil
+}
@@ -0,0 +1,160 @@
+package addon
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+func TestHarExporter(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "out.har")
+	har := NewHarExporter(filename, "test")
+
+	start := time.Now()
+	f := &proxy.Flow{
+		Request: &proxy.Request{
+			Method: "POST",
+			URL:    &url.URL{Scheme: "https", Host: "example.com", Path: "/api", RawQuery: "a=1&b=2"},
+			Proto:  "HTTP/1.1",
+			Header: http.Header{"Content-Type": {"application/json"}, "Cookie": {"k=v"}},
+			Body:   []byte(`{"hello":"world"}`),
+		},
+		Response: &proxy.Response{
+			StatusCode: 200,
+			Header:     http.Header{"Content-Type": {"text/plain"}},
+			Body:       []byte("ok"),
+		},
+		StartTime: start,
+		Timing: &proxy.Timing{
+			RequestHeaders:    start,
+			RequestSent:       start.Add(time.Millisecond),
+			FirstResponseByte: start.Add(3 * time.Millisecond),
+			ResponseComplete:  start.Add(6 * time.Millisecond),
+		},
+	}
+	har.Response(f)
+	har.RequestError(&proxy.Flow{
+		Request: &proxy.Request{
+			Method: "GET",
+			URL:    &url.URL{Scheme: "http", Host: "example.com", Path: "/"},
+			Proto:  "HTTP/1.1",
+			Header: http.Header{},
+		},
+		StartTime: start.Add(-time.Second),
+	}, errors.New("dial error"))
+
+	if err := har.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result struct {
+		Log struct {
+			Version string
+			Entries []struct {
+				Time    float64
+				Request struct {
+					Method      string
+					QueryString []harNameValue
+					Cookies     []harCookie
+					PostData    harPostData
+				}
+				Response struct {
+					Status  int
+					Content harContent
+					Error   string `json:"_error"`
+				}
+				Timings harTimings
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Log.Version != "1.2" || len(result.Log.Entries) != 2 {
+		t.Fatalf("unexpected har: %s", data)
+	}
+	// sorted by start time
+	errEntry, entry := result.Log.Entries[0], result.Log.Entries[1]
+	if errEntry.Response.Error != "dial error" {
+		t.Fatalf("expected error entry first, but got %+v", errEntry)
+	}
+	if entry.Request.Method != "POST" || len(entry.Request.QueryString) != 2 || len(entry.Request.Cookies) != 1 {
+		t.Fatalf("unexpected request: %+v", entry.Request)
+	}
+	if entry.Request.PostData.Text != `{"hello":"world"}` {
+		t.Fatalf("unexpected post data: %+v", entry.Request.PostData)
+	}
+	if entry.Response.Status != 200 || entry.Response.Content.Text != "ok" || entry.Response.Content.Size != 2 {
+		t.Fatalf("unexpected response: %+v", entry.Response)
+	}
+	if entry.Timings.DNS != -1 || entry.Timings.Send != 1 || entry.Timings.Wait != 2 || entry.Timings.Receive != 3 || entry.Time != 6 {
+		t.Fatalf("unexpected timings: %+v, time: %v", entry.Timings, entry.Time)
+	}
+}
+
+func TestHarExporterStream(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("a"), 1024))
+	}))
+	defer backend.Close()
+
+	filename := filepath.Join(t.TempDir(), "out.har")
+	har := NewHarExporter(filename, "test")
+	p, err := proxy.NewProxy(&proxy.Options{
+		Addr:              "127.0.0.1:29143",
+		StreamLargeBodies: 16,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.AddAddon(har)
+	go p.Start()
+	defer p.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy: func(r *http.Request) (*url.URL, error) {
+				return url.Parse("http://127.0.0.1:29143")
+			},
+		},
+	}
+	res, err := client.Get(backend.URL + "/large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+
+	// entry is added after the flow finished
+	var entries []*harEntry
+	for i := 0; i < 100; i++ {
+		har.mu.Lock()
+		entries = append(entries[:0], har.entries...)
+		har.mu.Unlock()
+		if len(entries) > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %v", len(entries))
+	}
+	if entries[0].Response.Status != 200 || entries[0].Response.BodySize != -1 || entries[0].Response.Content.Text != "" {
+		t.Fatalf("unexpected response: %+v", entries[0].Response)
+	}
+}
@@ -0,0 +1,155 @@
+package addon
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+func newModifyTestFlow() *proxy.Flow {
+	return &proxy.Flow{
+		Request: &proxy.Request{
+			Method: "GET",
+			URL:    &url.URL{Scheme: "https", Host: "example.com", Path: "/api"},
+			Header: http.Header{"Cookie": {"a=1"}, "X-Old": {"1"}},
+		},
+	}
+}
+
+func TestModifyHeaders(t *testing.T) {
+	mh := &ModifyHeaders{
+		Enable: true,
+		Items: []*modifyHeadersItem{
+			{
+				From:   &mapFrom{Host: "example.com"},
+				Target: modifyTargetRequest,
+				Set:    map[string]string{"Cookie": "b=2"},
+				Add:    map[string]string{"X-Add": "1"},
+				Remove: []string{"X-Old"},
+				Enable: true,
+			},
+			{
+				From:   &mapFrom{Path: "/api"},
+				Target: modifyTargetResponse,
+				Remove: []string{"Set-Cookie"},
+				Enable: true,
+			},
+			{
+				From:   &mapFrom{Host: "other.com"},
+				Set:    map[string]string{"X-Other": "1"},
+				Enable: true,
+			},
+		},
+	}
+	if err := mh.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	f := newModifyTestFlow()
+	mh.Requestheaders(f)
+	if got := f.Request.Header.Get("Cookie"); got != "b=2" {
+		t.Fatalf("expected Cookie b=2, got %v", got)
+	}
+	if f.Request.Header.Get("X-Add") != "1" || f.Request.Header.Get("X-Old") != "" || f.Request.Header.Get("X-Other") != "" {
+		t.Fatalf("unexpected request header %v", f.Request.Header)
+	}
+
+	f.Response = &proxy.Response{StatusCode: 200, Header: http.Header{"Set-Cookie": {"c=3"}, "Server": {"test"}}}
+	mh.Responseheaders(f)
+	if f.Response.Header.Get("Set-Cookie") != "" || f.Response.Header.Get("Server") != "test" {
+		t.Fatalf("unexpected response header %v", f.Response.Header)
+	}
+
+	mh.Items[0].Target = "invalid"
+	if err := mh.validate(); err == nil {
+		t.Fatal("expected invalid target error")
+	}
+}
+
+func TestModifyBody(t *testing.T) {
+	mb := &ModifyBody{
+		Enable: true,
+		Items: []*modifyBodyItem{
+			{
+				From:    &mapFrom{Host: "example.com"},
+				Target:  modifyTargetResponse,
+				Pattern: `"debug":\s*(\w+)`,
+				Replace: `"debug":true`,
+				Enable:  true,
+			},
+		},
+	}
+	if err := mb.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(`{"debug": false}`))
+	w.Close()
+
+	f := newModifyTestFlow()
+	f.Request.Body = []byte(`{"debug": false}`)
+	f.Response = &proxy.Response{
+		StatusCode: 200,
+		Header:     http.Header{"Content-Encoding": {"gzip"}},
+		Body:       buf.Bytes(),
+	}
+	mb.Request(f)
+	if string(f.Request.Body) != `{"debug": false}` {
+		t.Fatalf("request body should not be modified, got %s", f.Request.Body)
+	}
+	mb.Response(f)
+
+	if f.Response.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip encoding kept")
+	}
+	r, err := gzip.NewReader(bytes.NewReader(f.Response.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"debug":true}` {
+		t.Fatalf("unexpected body %s", body)
+	}
+
+	mb.Items[0].Pattern = "("
+	if err := mb.validate(); err == nil {
+		t.Fatal("expected invalid pattern error")
+	}
+}
+
+func TestNewModifyHeadersFromYaml(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "modify_headers.yaml")
+	content := `
+Enable: true
+Items:
+  - From:
+      Host: example.com
+      Method: [GET]
+    Target: request
+    Set:
+      User-Agent: go-mitmproxy
+    Enable: true
+`
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mh, err := NewModifyHeadersFromFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mh.Enable || len(mh.Items) != 1 || mh.Items[0].From.Host != "example.com" || mh.Items[0].Set["User-Agent"] != "go-mitmproxy" {
+		t.Fatalf("unexpected config %+v", mh)
+	}
+}
@@ -0,0 +1,113 @@
+package addon
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/lqqyt2423/go-mitmproxy/internal/helper"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+	log "github.com/sirupsen/logrus"
+)
+
+// replace the decoded body by regexp, then encode it by the original Content-Encoding
+// streamed body is not modified
+
+type modifyBodyItem struct {
+	From    *mapFrom
+	Target  string // request, response, or empty for both
+	Pattern string // regexp
+	Replace string // replacement, support $1 and ${name}
+	Enable  bool
+
+	re *regexp.Regexp
+}
+
+func (item *modifyBodyItem) match(req *proxy.Request, target string) bool {
+	if !item.Enable {
+		return false
+	}
+	if item.Target != modifyTargetBoth && item.Target != target {
+		return false
+	}
+	return item.From.match(req)
+}
+
+type ModifyBody struct {
+	proxy.BaseAddon
+	Items  []*modifyBodyItem
+	Enable bool
+}
+
+func (mb *ModifyBody) Request(f *proxy.Flow) {
+	if !mb.Enable || len(f.Request.Body) == 0 {
+		return
+	}
+	for _, item := range mb.Items {
+		if !item.match(f.Request, modifyTargetRequest) {
+			continue
+		}
+		body, err := f.Request.DecodedBody()
+		if err != nil {
+			log.Warnf("modify request body of %v error: %v", f.Request.URL, err)
+			return
+		}
+		log.Debugf("modify request body of %v", f.Request.URL)
+		f.Request.SetDecodedBody(item.re.ReplaceAll(body, []byte(item.Replace)))
+	}
+}
+
+func (mb *ModifyBody) Response(f *proxy.Flow) {
+	if !mb.Enable || len(f.Response.Body) == 0 {
+		return
+	}
+	if f.Response.Header == nil {
+		f.Response.Header = make(http.Header)
+	}
+	for _, item := range mb.Items {
+		if !item.match(f.Request, modifyTargetResponse) {
+			continue
+		}
+		body, err := f.Response.DecodedBody()
+		if err != nil {
+			log.Warnf("modify response body of %v error: %v", f.Request.URL, err)
+			return
+		}
+		log.Debugf("modify response body of %v", f.Request.URL)
+		f.Response.SetDecodedBody(item.re.ReplaceAll(body, []byte(item.Replace)))
+	}
+}
+
+func (mb *ModifyBody) validate() error {
+	for i, item := range mb.Items {
+		if item.From == nil {
+			return fmt.Errorf("%v no item.From", i)
+		}
+		if item.From.Protocol != "" && item.From.Protocol != "http" && item.From.Protocol != "https" {
+			return fmt.Errorf("%v invalid item.From.Protocol %v", i, item.From.Protocol)
+		}
+		if !validModifyTarget(item.Target) {
+			return fmt.Errorf("%v invalid item.Target %v", i, item.Target)
+		}
+		if item.Pattern == "" {
+			return fmt.Errorf("%v empty item.Pattern", i)
+		}
+		re, err := regexp.Compile(item.Pattern)
+		if err != nil {
+			return fmt.Errorf("%v invalid item.Pattern: %w", i, err)
+		}
+		item.re = re
+	}
+	return nil
+}
+
+func NewModifyBodyFromFile(filename string) (*ModifyBody, error) {
+	var modifyBody ModifyBody
+	if err := helper.NewStructFromFile(filename, &modifyBody); err != nil {
+		return nil, err
+	}
+	if err := modifyBody.validate(); err != nil {
+		return nil, err
+	}
+	return &modifyBody, nil
+}
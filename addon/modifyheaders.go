@@ -0,0 +1,114 @@
+package addon
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/lqqyt2423/go-mitmproxy/internal/helper"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+	log "github.com/sirupsen/logrus"
+)
+
+// modify target of the matched flow
+const (
+	modifyTargetRequest  = "request"
+	modifyTargetResponse = "response"
+	modifyTargetBoth     = "" // request and response
+)
+
+func validModifyTarget(target string) bool {
+	return target == modifyTargetRequest || target == modifyTargetResponse || target == modifyTargetBoth
+}
+
+type modifyHeadersItem struct {
+	From   *mapFrom
+	Target string            // request, response, or empty for both
+	Set    map[string]string // replace the values of header
+	Add    map[string]string // append value to header
+	Remove []string          // delete header
+	Enable bool
+}
+
+func (item *modifyHeadersItem) match(req *proxy.Request, target string) bool {
+	if !item.Enable {
+		return false
+	}
+	if item.Target != modifyTargetBoth && item.Target != target {
+		return false
+	}
+	return item.From.match(req)
+}
+
+func (item *modifyHeadersItem) modify(header http.Header) {
+	for _, name := range item.Remove {
+		header.Del(name)
+	}
+	for name, value := range item.Set {
+		header.Set(name, value)
+	}
+	for name, value := range item.Add {
+		header.Add(name, value)
+	}
+}
+
+type ModifyHeaders struct {
+	proxy.BaseAddon
+	Items  []*modifyHeadersItem
+	Enable bool
+}
+
+func (mh *ModifyHeaders) Requestheaders(f *proxy.Flow) {
+	if !mh.Enable {
+		return
+	}
+	for _, item := range mh.Items {
+		if item.match(f.Request, modifyTargetRequest) {
+			log.Debugf("modify request headers of %v", f.Request.URL)
+			item.modify(f.Request.Header)
+		}
+	}
+}
+
+func (mh *ModifyHeaders) Responseheaders(f *proxy.Flow) {
+	if !mh.Enable {
+		return
+	}
+	if f.Response.Header == nil {
+		f.Response.Header = make(http.Header)
+	}
+	for _, item := range mh.Items {
+		if item.match(f.Request, modifyTargetResponse) {
+			log.Debugf("modify response headers of %v", f.Request.URL)
+			item.modify(f.Response.Header)
+		}
+	}
+}
+
+func (mh *ModifyHeaders) validate() error {
+	for i, item := range mh.Items {
+		if item.From == nil {
+			return fmt.Errorf("%v no item.From", i)
+		}
+		if item.From.Protocol != "" && item.From.Protocol != "http" && item.From.Protocol != "https" {
+			return fmt.Errorf("%v invalid item.From.Protocol %v", i, item.From.Protocol)
+		}
+		if !validModifyTarget(item.Target) {
+			return fmt.Errorf("%v invalid item.Target %v", i, item.Target)
+		}
+		if len(item.Set) == 0 && len(item.Add) == 0 && len(item.Remove) == 0 {
+			return fmt.Errorf("%v empty item.Set, item.Add and item.Remove", i)
+		}
+	}
+	return nil
+}
+
+func NewModifyHeadersFromFile(filename string) (*ModifyHeaders, error) {
+	var modifyHeaders ModifyHeaders
+	if err := helper.NewStructFromFile(filename, &modifyHeaders); err != nil {
+		return nil, err
+	}
+	if err := modifyHeaders.validate(); err != nil {
+		return nil, err
+	}
+	return &modifyHeaders, nil
+}
@@ -0,0 +1,447 @@
+package addon
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/fsnotify/fsnotify"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+	log "github.com/sirupsen/logrus"
+)
+
+// Script run the hooks defined in javascript file, and reload it when the file changed.
+//
+// Hooks are global functions named after the addon events:
+//
+//	requestheaders, request, responseheaders, response, request_error,
+//	websocket_start, websocket_message, websocket_end, sse_start, sse_message, sse_end
+//
+// The first argument is a mutable view of the flow:
+//
+//	flow.request: { method, url, proto, headers, body }
+//	flow.response: { status, headers, body }, set it in request hooks to respond directly
+//
+// websocket_message receives the message as the second argument: { type, content, fromClient, injected, dropped },
+// modify type and content, or set dropped to true, to change what is forwarded.
+// headers is an object of name to value, or array of values if the header has multiple values.
+// body is the decoded text of buffered body, empty in requestheaders and responseheaders.
+// Request hooks can change flow.request and flow.response, response hooks can change flow.response,
+// the changes of flow in other hooks are ignored. Only the changed fields are applied back.
+// Hooks of all flows are called one by one, since the javascript runtime is single threaded and the global
+// variables of script are shared. A hook running longer than Timeout is interrupted.
+//
+// Example:
+//
+//	function response(flow) {
+//	  flow.response.headers['x-script'] = 'hello'
+//	}
+type Script struct {
+	proxy.BaseAddon
+	filename string
+	Timeout  time.Duration // max duration of a hook call, default 1s
+
+	mu      sync.Mutex
+	vm      *goja.Runtime
+	hooks   map[string]goja.Callable
+	watcher *fsnotify.Watcher
+}
+
+const defaultScriptTimeout = time.Second
+
+// the parts of flow applied back after the hook returned
+type scriptWritable struct {
+	request  bool
+	response bool
+}
+
+var scriptHookWritable = map[string]scriptWritable{
+	"requestheaders":  {request: true, response: true},
+	"request":         {request: true, response: true},
+	"responseheaders": {response: true},
+	"response":        {response: true},
+}
+
+var scriptHookNames = []string{
+	"requestheaders",
+	"request",
+	"responseheaders",
+	"response",
+	"request_error",
+	"websocket_start",
+	"websocket_message",
+	"websocket_end",
+	"sse_start",
+	"sse_message",
+	"sse_end",
+}
+
+func NewScript(filename string) (*Script, error) {
+	filename, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	s := &Script{filename: filename, Timeout: defaultScriptTimeout}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.watch(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close stop watching the script file, call it when the proxy exits
+func (s *Script) Close() error {
+	return s.watcher.Close()
+}
+
+func (s *Script) load() error {
+	code, err := os.ReadFile(s.filename)
+	if err != nil {
+		return err
+	}
+
+	vm := goja.New()
+	console := vm.NewObject()
+	console.Set("log", func(args ...interface{}) {
+		log.Info(append([]interface{}{"[script] "}, args...)...)
+	})
+	vm.Set("console", console)
+	if _, err := vm.RunScript(s.filename, string(code)); err != nil {
+		return err
+	}
+
+	hooks := make(map[string]goja.Callable)
+	for _, name := range scriptHookNames {
+		if fn, ok := goja.AssertFunction(vm.Get(name)); ok {
+			hooks[name] = fn
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vm = vm
+	s.hooks = hooks
+	return nil
+}
+
+// watch the dir, since editors may replace the file when saving
+func (s *Script) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(s.filename)); err != nil {
+		watcher.Close()
+		return err
+	}
+	s.watcher = watcher
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Name != s.filename || !event.Has(fsnotify.Write|fsnotify.Create) {
+					continue
+				}
+				if err := s.load(); err != nil {
+					log.Errorf("reload script %v error: %v", s.filename, err)
+				} else {
+					log.Infof("script %v reloaded", s.filename)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Errorf("watch script %v error: %v", s.filename, err)
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *Script) call(name string, f *proxy.Flow, args ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn, ok := s.hooks[name]
+	if !ok {
+		return
+	}
+
+	view := newScriptFlowView(f)
+	values := []goja.Value{s.vm.ToValue(view.m)}
+	for _, arg := range args {
+		values = append(values, s.vm.ToValue(arg))
+	}
+	if err := s.run(fn, values); err != nil {
+		log.Errorf("script %v %v error: %v", name, f.Request.URL, err)
+		return
+	}
+	writable, ok := scriptHookWritable[name]
+	if !ok {
+		return
+	}
+	if err := view.apply(f, writable); err != nil {
+		log.Errorf("script %v %v error: %v", name, f.Request.URL, err)
+	}
+}
+
+// run the hook with s.mu held, interrupt it after s.Timeout
+func (s *Script) run(fn goja.Callable, values []goja.Value) error {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = defaultScriptTimeout
+	}
+	vm := s.vm
+	interrupted := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() {
+		vm.Interrupt(fmt.Errorf("hook timeout after %v", timeout))
+		close(interrupted)
+	})
+	_, err := fn(goja.Undefined(), values...)
+	if !timer.Stop() {
+		<-interrupted
+	}
+	vm.ClearInterrupt()
+	return err
+}
+
+func (s *Script) Requestheaders(f *proxy.Flow) {
+	s.call("requestheaders", f)
+}
+
+func (s *Script) Request(f *proxy.Flow) {
+	s.call("request", f)
+}
+
+func (s *Script) Responseheaders(f *proxy.Flow) {
+	s.call("responseheaders", f)
+}
+
+func (s *Script) Response(f *proxy.Flow) {
+	s.call("response", f)
+}
+
+func (s *Script) RequestError(f *proxy.Flow, err error) {
+	s.call("request_error", f, err.Error())
+}
+
+func (s *Script) WebSocketStart(f *proxy.Flow) {
+	s.call("websocket_start", f)
+}
+
+func (s *Script) WebSocketMessage(f *proxy.Flow) {
+	if f.WebScoket == nil || len(f.WebScoket.Messages) == 0 {
+		return
+	}
+	msg := f.WebScoket.Messages[len(f.WebScoket.Messages)-1]
+	m := map[string]interface{}{
+		"type":       msg.Type,
+		"content":    string(msg.Content),
+		"fromClient": msg.FromClient,
+		"injected":   msg.Injected,
+		"dropped":    msg.Dropped,
+	}
+	s.call("websocket_message", f, m)
+
+	// the message is mutable
+	if content, ok := m["content"].(string); ok && content != string(msg.Content) {
+		msg.Content = []byte(content)
+	}
+	switch t := m["type"].(type) {
+	case int64:
+		msg.Type = int(t)
+	case float64:
+		msg.Type = int(t)
+	}
+	if dropped, ok := m["dropped"].(bool); ok {
+		msg.Dropped = dropped
+	}
+}
+
+func (s *Script) WebSocketEnd(f *proxy.Flow) {
+	s.call("websocket_end", f)
+}
+
+func (s *Script) SSEStart(f *proxy.Flow) {
+	s.call("sse_start", f)
+}
+
+func (s *Script) SSEMessage(f *proxy.Flow) {
+	if f.SSE == nil || len(f.SSE.Events) == 0 {
+		return
+	}
+	event := f.SSE.Events[len(f.SSE.Events)-1]
+	s.call("sse_message", f, map[string]interface{}{
+		"id":    event.ID,
+		"event": event.Event,
+		"data":  event.Data,
+		"retry": event.Retry,
+	})
+}
+
+func (s *Script) SSEEnd(f *proxy.Flow) {
+	s.call("sse_end", f)
+}
+
+// scriptFlowView is the flow passed to javascript, the changes are applied back after hook returned
+type scriptFlowView struct {
+	m         map[string]interface{}
+	reqURL    string
+	reqBody   string
+	reqHeader http.Header // as converted from the view, to find out whether the script changed headers
+	resBody   string
+	resHeader http.Header
+}
+
+func newScriptFlowView(f *proxy.Flow) *scriptFlowView {
+	v := &scriptFlowView{m: make(map[string]interface{})}
+	v.m["id"] = f.Id.String()
+	v.m["isReplay"] = f.IsReplay
+
+	req := f.Request
+	v.reqURL = req.URL.String()
+	if body, err := req.DecodedBody(); err == nil {
+		v.reqBody = string(body)
+	}
+	reqHeaders := scriptHeaders(req.Header)
+	v.reqHeader = scriptToHeader(reqHeaders)
+	v.m["request"] = map[string]interface{}{
+		"method":  req.Method,
+		"url":     v.reqURL,
+		"proto":   req.Proto,
+		"headers": reqHeaders,
+		"body":    v.reqBody,
+	}
+
+	if res := f.Response; res != nil {
+		if body, err := res.DecodedBody(); err == nil {
+			v.resBody = string(body)
+		}
+		resHeaders := scriptHeaders(res.Header)
+		v.resHeader = scriptToHeader(resHeaders)
+		v.m["response"] = map[string]interface{}{
+			"status":  res.StatusCode,
+			"headers": resHeaders,
+			"body":    v.resBody,
+		}
+	} else {
+		v.m["response"] = nil
+	}
+	return v
+}
+
+// apply the changed fields of the view to f, the flow may be read by other addons meanwhile
+func (v *scriptFlowView) apply(f *proxy.Flow, writable scriptWritable) error {
+	if writable.request {
+		req, ok := v.m["request"].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("flow.request should be object")
+		}
+		if method, ok := req["method"].(string); ok && method != f.Request.Method {
+			f.Request.Method = method
+		}
+		if rawurl, ok := req["url"].(string); ok && rawurl != v.reqURL {
+			u, err := url.Parse(rawurl)
+			if err != nil {
+				return err
+			}
+			f.Request.URL = u
+		}
+		if header := scriptToHeader(req["headers"]); !reflect.DeepEqual(header, v.reqHeader) {
+			f.Request.Header = header
+		}
+		if body, ok := req["body"].(string); ok && body != v.reqBody {
+			f.Request.Body = []byte(body)
+			f.Request.Header.Del("Content-Encoding")
+			f.Request.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		}
+	}
+
+	if !writable.response {
+		return nil
+	}
+	res, ok := v.m["response"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	isNew := f.Response == nil
+	if isNew {
+		f.Response = &proxy.Response{StatusCode: 200}
+	}
+	if status, ok := scriptToInt(res["status"]); ok && status != f.Response.StatusCode {
+		f.Response.StatusCode = status
+	}
+	if header := scriptToHeader(res["headers"]); isNew || !reflect.DeepEqual(header, v.resHeader) {
+		f.Response.Header = header
+	}
+	if body, ok := res["body"].(string); ok && (isNew || body != v.resBody) {
+		if !isNew {
+			f.Response.ReplaceToDecodedBody()
+		}
+		f.Response.Body = []byte(body)
+		f.Response.Header.Del("Content-Encoding")
+		f.Response.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	return nil
+}
+
+func scriptHeaders(header http.Header) map[string]interface{} {
+	m := make(map[string]interface{})
+	for name, values := range header {
+		if len(values) == 1 {
+			m[name] = values[0]
+			continue
+		}
+		vals := make([]interface{}, len(values))
+		for i, v := range values {
+			vals[i] = v
+		}
+		m[name] = vals
+	}
+	return m
+}
+
+func scriptToHeader(v interface{}) http.Header {
+	header := make(http.Header)
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return header
+	}
+	for name, value := range m {
+		switch value := value.(type) {
+		case nil:
+		case []interface{}:
+			for _, item := range value {
+				header.Add(name, fmt.Sprint(item))
+			}
+		default:
+			header.Add(name, fmt.Sprint(value))
+		}
+	}
+	return header
+}
+
+func scriptToInt(v interface{}) (int, bool) {
+	switch v := v.(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	default:
+		return 0, false
+	}
+}
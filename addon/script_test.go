@@ -0,0 +1,144 @@
+package addon
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+func TestScript(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "script.js")
+	writeScript := func(code string) {
+		if err := os.WriteFile(filename, []byte(code), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeScript(`
+function request(flow) {
+  flow.request.headers['X-Script'] = 'v1'
+  if (flow.request.url.indexOf('/mock') !== -1) {
+    flow.response = { status: 201, headers: { 'Content-Type': 'text/plain' }, body: 'mocked' }
+  }
+}
+function response(flow) {
+  flow.response.body = flow.response.body.toUpperCase()
+}
+function responseheaders(flow) {
+  flow.request.headers['X-Ignored'] = '1'
+}
+function request_error(flow) {
+  while (true) {}
+}
+function websocket_message(flow, message) {
+  flow.request.headers['X-Ignored'] = '1'
+  if (message.content === 'drop') {
+    message.dropped = true
+  } else {
+    message.content = message.content.toUpperCase()
+  }
+}
+`)
+	script, err := NewScript(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer script.Close()
+
+	newFlow := func(path string) *proxy.Flow {
+		return &proxy.Flow{
+			Request: &proxy.Request{
+				Method: "GET",
+				URL:    &url.URL{Scheme: "http", Host: "example.com", Path: path},
+				Header: http.Header{},
+			},
+		}
+	}
+
+	t.Run("modify", func(t *testing.T) {
+		f := newFlow("/")
+		script.Request(f)
+		if f.Request.Header.Get("X-Script") != "v1" || f.Response != nil {
+			t.Fatalf("unexpected flow: %+v %+v", f.Request, f.Response)
+		}
+		f.Response = &proxy.Response{StatusCode: 200, Header: http.Header{}, Body: []byte("ok")}
+		script.Response(f)
+		if string(f.Response.Body) != "OK" || f.Response.Header.Get("Content-Length") != "2" {
+			t.Fatalf("unexpected response: %+v", f.Response)
+		}
+	})
+
+	t.Run("respond", func(t *testing.T) {
+		f := newFlow("/mock")
+		script.Request(f)
+		if f.Response == nil || f.Response.StatusCode != 201 || string(f.Response.Body) != "mocked" || f.Response.Header.Get("Content-Type") != "text/plain" {
+			t.Fatalf("unexpected response: %+v", f.Response)
+		}
+	})
+
+	t.Run("websocket message", func(t *testing.T) {
+		f := newFlow("/ws")
+		f.WebScoket = &proxy.WebSocketData{}
+		for _, content := range []string{"hello", "drop"} {
+			f.WebScoket.Messages = append(f.WebScoket.Messages, &proxy.WebSocketMessage{Type: 1, Content: []byte(content), FromClient: true})
+			script.WebSocketMessage(f)
+		}
+		if msg := f.WebScoket.Messages[0]; string(msg.Content) != "HELLO" || msg.Dropped {
+			t.Fatalf("unexpected message: %+v", msg)
+		}
+		if msg := f.WebScoket.Messages[1]; !msg.Dropped {
+			t.Fatalf("expected message dropped: %+v", msg)
+		}
+	})
+
+	t.Run("keep unchanged and not writable fields", func(t *testing.T) {
+		f := newFlow("/")
+		f.Request.Header.Set("Accept", "*/*")
+		f.Response = &proxy.Response{StatusCode: 200, Header: http.Header{"Server": {"test"}}}
+		reqHeader, resHeader := f.Request.Header, f.Response.Header
+		script.Responseheaders(f)
+		f.WebScoket = &proxy.WebSocketData{Messages: []*proxy.WebSocketMessage{{Type: 1, Content: []byte("hi")}}}
+		script.WebSocketMessage(f)
+		if f.Request.Header.Get("X-Ignored") != "" {
+			t.Fatalf("request should not be changed in responseheaders and websocket_message: %v", f.Request.Header)
+		}
+		if reflect.ValueOf(f.Request.Header).Pointer() != reflect.ValueOf(reqHeader).Pointer() || reflect.ValueOf(f.Response.Header).Pointer() != reflect.ValueOf(resHeader).Pointer() {
+			t.Fatal("unchanged headers should not be replaced")
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		script.Timeout = time.Millisecond * 50
+		defer func() { script.Timeout = defaultScriptTimeout }()
+		start := time.Now()
+		script.RequestError(newFlow("/"), errors.New("test"))
+		if time.Since(start) > time.Second {
+			t.Fatal("busy hook should be interrupted")
+		}
+		// the runtime is usable after interrupted
+		f := newFlow("/")
+		script.Request(f)
+		if f.Request.Header.Get("X-Script") != "v1" {
+			t.Fatalf("unexpected flow: %+v", f.Request)
+		}
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		writeScript(`function request(flow) { flow.request.headers['X-Script'] = 'v2' }`)
+		for i := 0; i < 100; i++ {
+			f := newFlow("/")
+			script.Request(f)
+			if f.Request.Header.Get("X-Script") == "v2" {
+				return
+			}
+			time.Sleep(time.Millisecond * 20)
+		}
+		t.Fatal("script should be reloaded")
+	})
+}
@@ -0,0 +1,243 @@
+package addon
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/lqqyt2423/go-mitmproxy/internal/helper"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+	"github.com/samber/lo"
+	log "github.com/sirupsen/logrus"
+)
+
+// replay recorded responses instead of dialing upstream
+// the capture file is HAR, such as saved by HarExporter or browsers
+// requests with the same key are replayed in recorded order, the last one is reused after others are consumed
+// without Fallback, the request body is buffered by ServerReplay to match even it would be streamed, so it never goes to upstream
+// with Fallback, streamed requests (f.Stream) are matched only with IgnoreContent, otherwise sent to upstream
+
+type ServerReplay struct {
+	proxy.BaseAddon
+	Filename      string   // HAR file
+	IgnoreMethod  bool     // not match method
+	IgnoreHost    bool     // not match scheme and host
+	IgnorePath    bool     // not match path
+	IgnoreQuery   bool     // not match all query params
+	IgnoreParams  []string // query params not matched, such as timestamp
+	IgnoreContent bool     // not match sha256 of request body
+	Fallback      bool     // send to upstream when no recorded response matched, default respond 404
+
+	mu        sync.Mutex
+	responses map[string][]*harEntry
+}
+
+func (s *ServerReplay) Requestheaders(f *proxy.Flow) {
+	if s.IgnoreContent {
+		s.replay(f, nil)
+		return
+	}
+	if s.Fallback {
+		// need body to match
+		return
+	}
+	// the body may be streamed to upstream after this hook, read it here
+	raw := f.Request.Raw()
+	if raw == nil || raw.Body == nil {
+		return
+	}
+	body, err := io.ReadAll(raw.Body)
+	if err != nil {
+		log.Errorf("server replay read request body of %v error: %v", f.Request.URL, err)
+		f.Response = &proxy.Response{StatusCode: 502}
+		return
+	}
+	f.Request.Body = body
+	s.Request(f)
+}
+
+func (s *ServerReplay) Request(f *proxy.Flow) {
+	if s.IgnoreContent {
+		return
+	}
+	body, err := f.Request.DecodedBody()
+	if err != nil {
+		body = f.Request.Body
+	}
+	s.replay(f, body)
+}
+
+// the Request hook is skipped for streamed request, which has been sent to upstream with Fallback
+func (s *ServerReplay) Responseheaders(f *proxy.Flow) {
+	if f.Stream && !s.IgnoreContent && s.Fallback {
+		log.Warnf("server replay skip streamed request %v, the body is not buffered to match", f.Request.URL)
+	}
+}
+
+func (s *ServerReplay) replay(f *proxy.Flow, body []byte) {
+	key := s.key(f.Request.Method, f.Request.URL, body)
+	entry := s.next(key)
+	if entry == nil {
+		if s.Fallback {
+			log.Debugf("server replay miss %v, send to upstream", f.Request.URL)
+			return
+		}
+		log.Warnf("server replay miss %v", f.Request.URL)
+		f.Response = &proxy.Response{
+			StatusCode: 404,
+			Header:     http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+			Body:       []byte("no recorded response\n"),
+		}
+		return
+	}
+
+	resp, err := newReplayResponse(entry.Response)
+	if err != nil {
+		log.Errorf("server replay %v error: %v", f.Request.URL, err)
+		f.Response = &proxy.Response{StatusCode: 502}
+		return
+	}
+	log.Infof("server replay %v", f.Request.URL)
+	f.Response = resp
+}
+
+// pop the recorded response of the key, keep the last one
+func (s *ServerReplay) next(key string) *harEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := s.responses[key]
+	if len(entries) == 0 {
+		return nil
+	}
+	entry := entries[0]
+	if len(entries) > 1 {
+		s.responses[key] = entries[1:]
+	}
+	return entry
+}
+
+func (s *ServerReplay) key(method string, u *url.URL, body []byte) string {
+	parts := make([]string, 0, 5)
+	if !s.IgnoreMethod {
+		parts = append(parts, method)
+	}
+	if !s.IgnoreHost {
+		parts = append(parts, u.Scheme, u.Host)
+	}
+	if !s.IgnorePath {
+		parts = append(parts, u.Path)
+	}
+	if !s.IgnoreQuery {
+		query := u.Query()
+		for _, name := range s.IgnoreParams {
+			query.Del(name)
+		}
+		// Encode is sorted by key
+		parts = append(parts, query.Encode())
+	}
+	if !s.IgnoreContent {
+		hash := sha256.Sum256(body)
+		parts = append(parts, hex.EncodeToString(hash[:]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Load read recorded responses from Filename, call it again after the match options changed
+func (s *ServerReplay) Load() error {
+	harLog, err := readHarFile(s.Filename)
+	if err != nil {
+		return err
+	}
+
+	// entries are sorted by start time in har
+	responses := make(map[string][]*harEntry)
+	count := 0
+	for _, entry := range harLog.Entries {
+		if entry.Request == nil || entry.Response == nil || entry.Response.Status <= 0 {
+			continue
+		}
+		u, err := url.Parse(entry.Request.URL)
+		if err != nil {
+			log.Warnf("server replay skip invalid url %v", entry.Request.URL)
+			continue
+		}
+		var body []byte
+		if entry.Request.PostData != nil {
+			body, err = harDecodeText(entry.Request.PostData.Text, entry.Request.PostData.Encoding)
+			if err != nil {
+				log.Warnf("server replay skip %v: %v", entry.Request.URL, err)
+				continue
+			}
+		}
+		key := s.key(entry.Request.Method, u, body)
+		responses[key] = append(responses[key], entry)
+		count++
+	}
+
+	s.mu.Lock()
+	s.responses = responses
+	s.mu.Unlock()
+	log.Infof("server replay loaded %v responses from %v", count, s.Filename)
+	return nil
+}
+
+func newReplayResponse(r *harResponse) (*proxy.Response, error) {
+	var body []byte
+	if r.Content != nil {
+		var err error
+		body, err = harDecodeText(r.Content.Text, r.Content.Encoding)
+		if err != nil {
+			return nil, err
+		}
+	}
+	header := make(http.Header)
+	for _, h := range r.Headers {
+		header.Add(h.Name, h.Value)
+	}
+	// body in har is decoded, and the length is recalculated
+	for _, name := range []string{"Content-Encoding", "Content-Length", "Transfer-Encoding"} {
+		header.Del(name)
+	}
+	return &proxy.Response{
+		StatusCode: r.Status,
+		Header:     header,
+		Body:       body,
+	}, nil
+}
+
+func harDecodeText(text string, encoding string) ([]byte, error) {
+	if encoding == "base64" {
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return []byte(text), nil
+}
+
+func (s *ServerReplay) validate() error {
+	if s.Filename == "" {
+		return fmt.Errorf("empty Filename")
+	}
+	if lo.Contains(s.IgnoreParams, "") {
+		return fmt.Errorf("empty item of IgnoreParams")
+	}
+	return nil
+}
+
+func NewServerReplayFromFile(filename string) (*ServerReplay, error) {
+	var serverReplay ServerReplay
+	if err := helper.NewStructFromFile(filename, &serverReplay); err != nil {
+		return nil, err
+	}
+	if err := serverReplay.validate(); err != nil {
+		return nil, err
+	}
+	if err := serverReplay.Load(); err != nil {
+		return nil, err
+	}
+	return &serverReplay, nil
+}
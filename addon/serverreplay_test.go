@@ -0,0 +1,161 @@
+package addon
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+func TestServerReplay(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "replay.har")
+	har := NewHarExporter(filename, "test")
+	newFlow := func(rawurl string, body string) *proxy.Flow {
+		u, _ := url.Parse(rawurl)
+		return &proxy.Flow{
+			Request: &proxy.Request{
+				Method: "POST",
+				URL:    u,
+				Proto:  "HTTP/1.1",
+				Header: http.Header{},
+				Body:   []byte(body),
+			},
+		}
+	}
+	for _, body := range []string{"first", "second"} {
+		f := newFlow("https://example.com/api?id=1&t=100", "req")
+		f.Response = &proxy.Response{StatusCode: 200, Header: http.Header{"Content-Length": {"5"}}, Body: []byte(body)}
+		har.Response(f)
+	}
+	if err := har.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	replay := &ServerReplay{Filename: filename, IgnoreParams: []string{"t"}}
+	if err := replay.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectBody := func(t *testing.T, f *proxy.Flow, statusCode int, body string) {
+		t.Helper()
+		if f.Response == nil {
+			t.Fatal("expected response, but got nil")
+		}
+		if f.Response.StatusCode != statusCode || string(f.Response.Body) != body {
+			t.Fatalf("expected %v %v, but got %v %s", statusCode, body, f.Response.StatusCode, f.Response.Body)
+		}
+	}
+
+	t.Run("replay in order", func(t *testing.T) {
+		for _, body := range []string{"first", "second", "second"} {
+			f := newFlow("https://example.com/api?t=200&id=1", "req")
+			replay.Request(f)
+			expectBody(t, f, 200, body)
+			if f.Response.Header.Get("Content-Length") != "" {
+				t.Fatal("Content-Length should be removed")
+			}
+		}
+	})
+
+	t.Run("miss", func(t *testing.T) {
+		f := newFlow("https://example.com/api?id=2", "req")
+		replay.Request(f)
+		expectBody(t, f, 404, "no recorded response\n")
+
+		f = newFlow("https://example.com/api?id=1", "other body")
+		replay.Request(f)
+		expectBody(t, f, 404, "no recorded response\n")
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		replay.Fallback = true
+		defer func() { replay.Fallback = false }()
+		f := newFlow("https://example.com/api?id=2", "req")
+		replay.Request(f)
+		if f.Response != nil {
+			t.Fatal("expected no response to send to upstream")
+		}
+	})
+
+	t.Run("ignore content", func(t *testing.T) {
+		replay.IgnoreContent = true
+		if err := replay.Load(); err != nil {
+			t.Fatal(err)
+		}
+		f := newFlow("https://example.com/api?id=1", "other body")
+		replay.Requestheaders(f)
+		expectBody(t, f, 200, "first")
+	})
+}
+
+func TestServerReplayStreamedRequest(t *testing.T) {
+	var hits atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Write([]byte("live"))
+	}))
+	defer backend.Close()
+
+	body := bytes.Repeat([]byte("a"), 1024)
+	filename := filepath.Join(t.TempDir(), "replay.har")
+	har := NewHarExporter(filename, "test")
+	u, _ := url.Parse(backend.URL + "/upload")
+	har.Response(&proxy.Flow{
+		Request:  &proxy.Request{Method: "POST", URL: u, Proto: "HTTP/1.1", Header: http.Header{}, Body: body},
+		Response: &proxy.Response{StatusCode: 200, Header: http.Header{}, Body: []byte("recorded")},
+	})
+	if err := har.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	replay := &ServerReplay{Filename: filename}
+	if err := replay.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the request body is larger than StreamLargeBodies
+	p, err := proxy.NewProxy(&proxy.Options{
+		Addr:              "127.0.0.1:29145",
+		StreamLargeBodies: 16,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.AddAddon(replay)
+	go p.Start()
+	defer p.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy: func(r *http.Request) (*url.URL, error) {
+				return url.Parse("http://127.0.0.1:29145")
+			},
+		},
+	}
+	post := func(body []byte) (int, string) {
+		res, err := client.Post(u.String(), "text/plain", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(data)
+	}
+
+	if code, data := post(body); code != 200 || data != "recorded" {
+		t.Fatalf("expected recorded response, got %v %v", code, data)
+	}
+	if code, _ := post(bytes.Repeat([]byte("b"), 1024)); code != 404 {
+		t.Fatalf("expected 404, got %v", code)
+	}
+	if hits.Load() != 0 {
+		t.Fatalf("expected no request to upstream, got %v", hits.Load())
+	}
+}
@@ -9,3 +9,10 @@ type CA interface {
 	GetRootCA() *x509.Certificate
 	GetCert(commonName string) (*tls.Certificate, error)
 }
+
+// ConnCA is optional for CA, to generate certificate by the client hello and the upstream certificate.
+// chi.ServerName is the fallback host if client sent no SNI, upstream is nil if not connected to upstream before handshake.
+type ConnCA interface {
+	CA
+	GetCertForConn(chi *tls.ClientHelloInfo, upstream *x509.Certificate) (*tls.Certificate, error)
+}
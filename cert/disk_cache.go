@@ -0,0 +1,133 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 服务器证书磁盘缓存，保存在 StorePath/certs/<根证书指纹> 下，根证书变化后旧的缓存会被删除
+
+// refresh the cached certificate if it expires soon
+const diskCacheMinValidity = time.Hour * 24
+
+func (ca *SelfSignCA) diskCacheRoot() string {
+	return filepath.Join(ca.StorePath, "certs")
+}
+
+// the dir of the current root ca
+func (ca *SelfSignCA) diskCacheDir() string {
+	sum := sha256.Sum256(ca.RootCert.Raw)
+	return filepath.Join(ca.diskCacheRoot(), hex.EncodeToString(sum[:8]))
+}
+
+// leaf key type is part of the file name, so that the cache is not used after it changed
+func (ca *SelfSignCA) diskCacheFile(key string) string {
+	sum := sha256.Sum256([]byte(key + "|" + string(ca.LeafKeyType)))
+	return filepath.Join(ca.diskCacheDir(), hex.EncodeToString(sum[:16])+".pem")
+}
+
+// remove the cache of other root ca
+func (ca *SelfSignCA) cleanDiskCache() {
+	entries, err := os.ReadDir(ca.diskCacheRoot())
+	if err != nil {
+		return
+	}
+	current := filepath.Base(ca.diskCacheDir())
+	for _, entry := range entries {
+		if entry.Name() == current {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(ca.diskCacheRoot(), entry.Name())); err != nil {
+			log.Warnf("ca remove disk cache error: %v", err)
+		}
+	}
+}
+
+func (ca *SelfSignCA) loadDiskCache(key string) *tls.Certificate {
+	if !ca.DiskCache {
+		return nil
+	}
+	data, err := os.ReadFile(ca.diskCacheFile(key))
+	if err != nil {
+		return nil
+	}
+
+	certBlock, data := pem.Decode(data)
+	if certBlock == nil || certBlock.Type != "CERTIFICATE" {
+		return nil
+	}
+	leaf, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil || time.Until(leaf.NotAfter) < diskCacheMinValidity {
+		return nil
+	}
+
+	// no key saved if the leaf shares the root ca key
+	privateKey := ca.signer()
+	if keyBlock, _ := pem.Decode(data); keyBlock != nil {
+		if privateKey, err = parsePrivateKey(keyBlock.Bytes); err != nil {
+			return nil
+		}
+	} else if !ca.leafKeyShared() {
+		return nil
+	}
+
+	log.Debugf("ca load disk cache: %v", key)
+	return &tls.Certificate{
+		Certificate: ca.leafChain(certBlock.Bytes),
+		PrivateKey:  privateKey,
+		Leaf:        leaf,
+	}
+}
+
+func (ca *SelfSignCA) saveDiskCache(key string, cert *tls.Certificate) {
+	if !ca.DiskCache {
+		return
+	}
+	if err := ca.writeDiskCache(key, cert); err != nil {
+		log.Warnf("ca save disk cache error: %v", err)
+	}
+}
+
+func (ca *SelfSignCA) writeDiskCache(key string, cert *tls.Certificate) error {
+	buf := bytes.NewBuffer(nil)
+	if err := pem.Encode(buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}); err != nil {
+		return err
+	}
+	if !ca.leafKeyShared() {
+		keyBytes, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+		if err != nil {
+			return err
+		}
+		if err := pem.Encode(buf, &pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}); err != nil {
+			return err
+		}
+	}
+
+	dir := ca.diskCacheDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	// write to temp file then rename, avoid reading partial file by other process
+	file, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), ca.diskCacheFile(key))
+}
@@ -0,0 +1,67 @@
+package cert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+)
+
+// KeyType type of the private key
+type KeyType string
+
+const (
+	KeyTypeRSA       KeyType = "rsa" // RSA 2048
+	KeyTypeECDSAP256 KeyType = "ecdsa-p256"
+	KeyTypeECDSAP384 KeyType = "ecdsa-p384"
+	KeyTypeEd25519   KeyType = "ed25519"
+
+	// LeafKeyShared leaf certificates share the root ca key instead of generating their own
+	LeafKeyShared KeyType = "shared"
+)
+
+const defaultLeafKeyType = KeyTypeECDSAP256
+
+// ParseKeyType parse the key type, empty string is KeyTypeRSA
+func ParseKeyType(s string) (KeyType, error) {
+	switch keyType := KeyType(s); keyType {
+	case "":
+		return KeyTypeRSA, nil
+	case KeyTypeRSA, KeyTypeECDSAP256, KeyTypeECDSAP384, KeyTypeEd25519:
+		return keyType, nil
+	default:
+		return "", fmt.Errorf("invalid key type %v, should be one of rsa, ecdsa-p256, ecdsa-p384, ed25519", s)
+	}
+}
+
+// ParseLeafKeyType parse the key type of leaf certificates, which could also be LeafKeyShared,
+// empty string is the default ecdsa-p256
+func ParseLeafKeyType(s string) (KeyType, error) {
+	switch keyType := KeyType(s); keyType {
+	case "":
+		return defaultLeafKeyType, nil
+	case LeafKeyShared:
+		return keyType, nil
+	default:
+		return ParseKeyType(s)
+	}
+}
+
+func generateKey(keyType KeyType) (crypto.Signer, error) {
+	switch keyType {
+	case "", KeyTypeRSA:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyTypeECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyTypeEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("invalid key type %v", keyType)
+	}
+}
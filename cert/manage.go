@@ -0,0 +1,212 @@
+package cert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// 根证书管理：导出、指纹、轮换、导入及签发中间证书
+
+// export formats of the ca
+const (
+	FormatPEM = "pem"
+	FormatDER = "der"
+	FormatP12 = "p12"
+)
+
+// Fingerprint SHA-256 fingerprint of the certificate, such as AB:CD:...
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return fingerprintHex(sum[:])
+}
+
+// FingerprintSHA1 SHA-1 fingerprint of the certificate, shown by some systems
+func FingerprintSHA1(cert *x509.Certificate) string {
+	sum := sha1.Sum(cert.Raw)
+	return fingerprintHex(sum[:])
+}
+
+func fingerprintHex(sum []byte) string {
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// Export the root certificate in format pem, der or p12.
+// withKey exports the private key and the certificate chain instead, not supported by der. password is used by p12 only.
+func (ca *SelfSignCA) Export(format string, withKey bool, password string) ([]byte, error) {
+	switch format {
+	case FormatPEM:
+		buf := bytes.NewBuffer(nil)
+		var err error
+		if withKey {
+			err = ca.saveTo(buf)
+		} else {
+			err = ca.saveCertTo(buf)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case FormatDER:
+		if withKey {
+			return nil, errors.New("der format not support private key")
+		}
+		return ca.GetRootCA().Raw, nil
+	case FormatP12:
+		if withKey {
+			return pkcs12.Modern.Encode(ca.signer(), &ca.RootCert, ca.Chain, password)
+		}
+		return pkcs12.Modern.EncodeTrustStore([]*x509.Certificate{ca.GetRootCA()}, password)
+	default:
+		return nil, fmt.Errorf("invalid format %v, should be one of pem, der, p12", format)
+	}
+}
+
+// Rotate create and save a new root ca, cached leaf certificates are cleared
+func (ca *SelfSignCA) Rotate(keyType KeyType, validity time.Duration) error {
+	if validity <= 0 {
+		validity = defaultRootValidity
+	}
+	ca.cacheMu.Lock()
+	defer ca.cacheMu.Unlock()
+	ca.Chain = nil
+	if err := ca.create(keyType, validity); err != nil {
+		return err
+	}
+	if ca.cache != nil {
+		ca.cache.Clear()
+	}
+	if ca.DiskCache {
+		ca.cleanDiskCache()
+	}
+	return nil
+}
+
+// SignIntermediate create an intermediate ca signed by the ca, return its key and certificate chain.
+// The chain starts with the intermediate ca, followed by its issuers, the last one is the root.
+func (ca *SelfSignCA) SignIntermediate(keyType KeyType, validity time.Duration) (crypto.Signer, []*x509.Certificate, error) {
+	if validity <= 0 {
+		validity = defaultRootValidity
+	}
+	key, err := generateKey(keyType)
+	if err != nil {
+		return nil, nil, err
+	}
+	notBefore := time.Now().Add(-time.Hour * 48)
+	notAfter := notBefore.Add(validity)
+	if notAfter.After(ca.RootCert.NotAfter) {
+		log.Warnf("intermediate ca expires after the issuer, use the expiry of the issuer %v", ca.RootCert.NotAfter)
+		notAfter = ca.RootCert.NotAfter
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano() / 100000),
+		Subject: pkix.Name{
+			CommonName:   "mitmproxy intermediate",
+			Organization: []string{"mitmproxy"},
+		},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, &ca.RootCert, key.Public(), ca.signer())
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	chain := []*x509.Certificate{cert, &ca.RootCert}
+	return key, append(chain, ca.Chain...), nil
+}
+
+// ParseKeyPair parse the PEM encoded private key and certificate chain, the first certificate is of the key
+func ParseKeyPair(certPEM []byte, keyPEM []byte) (crypto.Signer, []*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	var key crypto.Signer
+	for _, data := range [][]byte{certPEM, keyPEM} {
+		for {
+			var block *pem.Block
+			if block, data = pem.Decode(data); block == nil {
+				break
+			}
+			if block.Type == "CERTIFICATE" {
+				cert, err := x509.ParseCertificate(block.Bytes)
+				if err != nil {
+					return nil, nil, err
+				}
+				chain = append(chain, cert)
+			} else if strings.HasSuffix(block.Type, "PRIVATE KEY") && key == nil {
+				var err error
+				if key, err = parsePrivateKey(block.Bytes); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+	}
+	if key == nil {
+		return nil, nil, errors.New("no PRIVATE KEY found")
+	}
+	if len(chain) == 0 {
+		return nil, nil, errors.New("no CERTIFICATE found")
+	}
+	return key, chain, nil
+}
+
+// SaveSelfSignCA save the private key and certificate chain to the store path, as the ca of NewSelfSignCA.
+// chain[0] is the certificate of the key, the followings are its issuers if it's an intermediate ca.
+func SaveSelfSignCA(path string, key crypto.Signer, chain []*x509.Certificate) error {
+	if len(chain) == 0 {
+		return errors.New("empty certificate chain")
+	}
+	if !chain[0].IsCA {
+		return errors.New("certificate is not a ca")
+	}
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(chain[0].PublicKey) {
+		return errors.New("private key does not match the certificate")
+	}
+	for i := 1; i < len(chain); i++ {
+		if err := chain[i-1].CheckSignatureFrom(chain[i]); err != nil {
+			return fmt.Errorf("certificate %v is not signed by the next: %w", i-1, err)
+		}
+	}
+
+	storePath, err := getStorePath(path)
+	if err != nil {
+		return err
+	}
+	ca := &SelfSignCA{
+		RootCert:  *chain[0],
+		Chain:     chain[1:],
+		StorePath: storePath,
+	}
+	ca.setKey(key)
+	if err := ca.save(); err != nil {
+		return err
+	}
+	return ca.saveCert()
+}
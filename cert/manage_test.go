@@ -0,0 +1,154 @@
+package cert
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestIntermediate(t *testing.T) {
+	rootApi, err := NewSelfSignCAMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := rootApi.(*SelfSignCA)
+
+	key, chain, err := root.SignIntermediate(KeyTypeECDSAP256, time.Hour*24*365)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := SaveSelfSignCA(dir, key, chain); err != nil {
+		t.Fatal(err)
+	}
+	caApi, err := NewSelfSignCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := caApi.(*SelfSignCA)
+	if !ca.GetRootCA().Equal(root.GetRootCA()) {
+		t.Fatal("root of intermediate ca should be the signing ca")
+	}
+
+	tlsCert, err := ca.GetCert("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tlsCert.Certificate) != 2 {
+		t.Fatalf("expected leaf and intermediate certificates, got %v", len(tlsCert.Certificate))
+	}
+	leaf, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	intermediate, err := x509.ParseCertificate(tlsCert.Certificate[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(root.GetRootCA())
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(intermediate)
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: roots, Intermediates: intermediates}); err != nil {
+		t.Fatal(err)
+	}
+
+	// key not matched
+	if err := SaveSelfSignCA(t.TempDir(), root.Signer, chain); err == nil {
+		t.Fatal("expected key mismatch error")
+	}
+
+	// default validity is longer than the root
+	_, chain, err = root.SignIntermediate(KeyTypeECDSAP256, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !chain[0].NotAfter.Equal(root.RootCert.NotAfter) {
+		t.Fatalf("expected expiry of the root %v, got %v", root.RootCert.NotAfter, chain[0].NotAfter)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	caApi, err := NewSelfSignCAMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := caApi.(*SelfSignCA)
+
+	der, err := ca.Export(FormatDER, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ca.Export(FormatDER, true, ""); err == nil {
+		t.Fatal("der should not support private key")
+	}
+
+	p12, err := ca.Export(FormatP12, true, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, p12Cert, _, err := pkcs12.DecodeChain(p12, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p12Cert.Equal(ca.GetRootCA()) {
+		t.Fatal("p12 certificate should equal")
+	}
+
+	pemData, err := ca.Export(FormatPEM, true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, chain, err := ParseKeyPair(pemData, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := SaveSelfSignCA(dir, key, chain); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := NewSelfSignCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Fingerprint(imported.GetRootCA()) != Fingerprint(ca.GetRootCA()) {
+		t.Fatal("imported ca should equal")
+	}
+
+	old := Fingerprint(ca.GetRootCA())
+	if err := imported.(*SelfSignCA).Rotate(KeyTypeECDSAP256, 0); err != nil {
+		t.Fatal(err)
+	}
+	if Fingerprint(imported.GetRootCA()) == old {
+		t.Fatal("root ca should be changed after rotated")
+	}
+}
+
+func TestLoadSelfSignCA(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "typo")
+	if _, err := LoadSelfSignCA(dir); err == nil {
+		t.Fatal("expected ca not found error")
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatal("should not create the store path")
+	}
+
+	created, err := NewSelfSignCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSelfSignCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Fingerprint(loaded.GetRootCA()) != Fingerprint(created.GetRootCA()) {
+		t.Fatal("loaded ca should equal")
+	}
+}
@@ -1,6 +1,7 @@
 package cert
 
 import (
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
@@ -15,6 +16,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -22,6 +24,7 @@ import (
 	"github.com/golang/groupcache/lru"
 	"github.com/golang/groupcache/singleflight"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/publicsuffix"
 )
 
 // reference
@@ -30,10 +33,34 @@ import (
 
 var errCaNotFound = errors.New("ca not found")
 
+const (
+	defaultRootValidity = time.Hour * 24 * 365 * 3
+	defaultLeafValidity = time.Hour * 24 * 397 // Apple 限制证书有效期不超过 398 天
+	defaultCacheSize    = 100
+)
+
+// SelfSignCAOptions 证书的密钥类型、有效期及缓存
+type SelfSignCAOptions struct {
+	RootKeyType  KeyType       // key type of new root ca, default rsa, the stored root ca is not changed
+	RootValidity time.Duration // validity of new root ca, default 3 years
+	LeafKeyType  KeyType       // key type generated for each leaf certificate, default ecdsa-p256, LeafKeyShared to share the root ca key
+	LeafValidity time.Duration // validity of leaf certificates, default 397 days
+	Wildcard     bool          // issue *.parent certificate for subdomains
+	CacheSize    int           // max leaf certificates cached in memory, default 100
+	DiskCache    bool          // also cache leaf certificates under StorePath, not work for memory ca
+	WarmHosts    []string      // generate certificates of the hosts in background after ca created
+}
+
 type SelfSignCA struct {
-	rsa.PrivateKey
-	RootCert  x509.Certificate
-	StorePath string
+	rsa.PrivateKey                     // key of RootCert if it's rsa, kept for compatibility, use Signer instead
+	Signer         crypto.Signer       // key of RootCert of any KeyType, PrivateKey is used if nil
+	RootCert       x509.Certificate    // the ca signs leaf certificates, could be an intermediate ca
+	Chain          []*x509.Certificate // issuers of RootCert if it's an intermediate ca, the last one is the root
+	StorePath      string
+	Wildcard       bool          // issue *.parent certificate for subdomains, reduce certificates generated
+	LeafKeyType    KeyType       // key type generated for each leaf certificate, empty or LeafKeyShared to share the root ca key
+	LeafValidity   time.Duration // validity of leaf certificates
+	DiskCache      bool          // cache leaf certificates under StorePath
 
 	cache *lru.Cache
 	group *singleflight.Group
@@ -41,11 +68,12 @@ type SelfSignCA struct {
 	cacheMu sync.Mutex
 }
 
-func createCert() (*rsa.PrivateKey, *x509.Certificate, error) {
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
+func createCert(keyType KeyType, validity time.Duration) (crypto.Signer, *x509.Certificate, error) {
+	key, err := generateKey(keyType)
 	if err != nil {
 		return nil, nil, err
 	}
+	notBefore := time.Now().Add(-time.Hour * 48)
 
 	template := &x509.Certificate{
 		SerialNumber: big.NewInt(time.Now().UnixNano() / 100000),
@@ -53,11 +81,10 @@ func createCert() (*rsa.PrivateKey, *x509.Certificate, error) {
 			CommonName:   "mitmproxy",
 			Organization: []string{"mitmproxy"},
 		},
-		NotBefore:             time.Now().Add(-time.Hour * 48),
-		NotAfter:              time.Now().Add(time.Hour * 24 * 365 * 3),
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(validity),
 		BasicConstraintsValid: true,
 		IsCA:                  true,
-		SignatureAlgorithm:    x509.SHA256WithRSA,
 		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 		ExtKeyUsage: []x509.ExtKeyUsage{
 			x509.ExtKeyUsageServerAuth,
@@ -71,7 +98,7 @@ func createCert() (*rsa.PrivateKey, *x509.Certificate, error) {
 		},
 	}
 
-	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -83,33 +110,84 @@ func createCert() (*rsa.PrivateKey, *x509.Certificate, error) {
 	return key, cert, nil
 }
 
+func (opts *SelfSignCAOptions) withDefault() *SelfSignCAOptions {
+	o := SelfSignCAOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.RootKeyType == "" {
+		o.RootKeyType = KeyTypeRSA
+	}
+	if o.LeafKeyType == "" {
+		o.LeafKeyType = defaultLeafKeyType
+	}
+	if o.RootValidity <= 0 {
+		o.RootValidity = defaultRootValidity
+	}
+	if o.LeafValidity <= 0 {
+		o.LeafValidity = defaultLeafValidity
+	}
+	if o.CacheSize <= 0 {
+		o.CacheSize = defaultCacheSize
+	}
+	return &o
+}
+
+func newSelfSignCA(storePath string, opts *SelfSignCAOptions) *SelfSignCA {
+	return &SelfSignCA{
+		StorePath:    storePath,
+		Wildcard:     opts.Wildcard,
+		LeafKeyType:  opts.LeafKeyType,
+		LeafValidity: opts.LeafValidity,
+		DiskCache:    opts.DiskCache && storePath != "",
+		cache:        lru.New(opts.CacheSize),
+		group:        new(singleflight.Group),
+	}
+}
+
+// prepare the disk cache and warm up after root ca loaded
+func (ca *SelfSignCA) init(opts *SelfSignCAOptions) {
+	if ca.DiskCache {
+		ca.cleanDiskCache()
+	}
+	if len(opts.WarmHosts) > 0 {
+		go ca.Warm(opts.WarmHosts)
+	}
+}
+
 // NewSelfSignCAMemory Create new ca only live in memory, will change when process restart
 func NewSelfSignCAMemory() (CA, error) {
-	key, cert, err := createCert()
+	return NewSelfSignCAMemoryWithOptions(nil)
+}
+
+// NewSelfSignCAMemoryWithOptions Create new ca only live in memory with options, opts could be nil
+func NewSelfSignCAMemoryWithOptions(opts *SelfSignCAOptions) (CA, error) {
+	opts = opts.withDefault()
+	key, cert, err := createCert(opts.RootKeyType, opts.RootValidity)
 	if err != nil {
 		return nil, err
 	}
-	return &SelfSignCA{
-		PrivateKey: *key,
-		RootCert:   *cert,
-		StorePath:  "",
-		cache:      lru.New(100),
-		group:      new(singleflight.Group),
-	}, nil
+	ca := newSelfSignCA("", opts)
+	ca.setKey(key)
+	ca.RootCert = *cert
+	ca.init(opts)
+	return ca, nil
 }
 
 // NewSelfSignCA Load ca from store path or create new ca then store
 func NewSelfSignCA(path string) (CA, error) {
+	return NewSelfSignCAWithOptions(path, nil)
+}
+
+// NewSelfSignCAWithOptions Load ca from store path or create new ca with options then store, opts could be nil
+func NewSelfSignCAWithOptions(path string, opts *SelfSignCAOptions) (CA, error) {
+	opts = opts.withDefault()
 	storePath, err := getStorePath(path)
 	if err != nil {
 		return nil, err
 	}
 
-	ca := &SelfSignCA{
-		StorePath: storePath,
-		cache:     lru.New(100),
-		group:     new(singleflight.Group),
-	}
+	ca := newSelfSignCA(storePath, opts)
 
 	if err := ca.load(); err != nil {
 		if err != errCaNotFound {
@@ -117,17 +195,36 @@ func NewSelfSignCA(path string) (CA, error) {
 		}
 	} else {
 		log.Debug("load root ca")
+		ca.init(opts)
 		return ca, nil
 	}
 
-	if err := ca.create(); err != nil {
+	if err := ca.create(opts.RootKeyType, opts.RootValidity); err != nil {
 		return nil, err
 	}
 	log.Debug("create root ca")
+	ca.init(opts)
 	return ca, nil
 }
 
-func getStorePath(path string) (string, error) {
+// LoadSelfSignCA Load ca from store path, return error if not found instead of creating new ca
+func LoadSelfSignCA(path string) (*SelfSignCA, error) {
+	storePath, err := resolveStorePath(path)
+	if err != nil {
+		return nil, err
+	}
+	ca := newSelfSignCA(storePath, (*SelfSignCAOptions)(nil).withDefault())
+	if err := ca.load(); err != nil {
+		if err == errCaNotFound {
+			return nil, fmt.Errorf("ca not found in %v", storePath)
+		}
+		return nil, err
+	}
+	return ca, nil
+}
+
+// absolute store path, default ~/.mitmproxy
+func resolveStorePath(path string) (string, error) {
 	if path == "" {
 		homeDir, err := os.UserHomeDir()
 		if err != nil {
@@ -143,6 +240,14 @@ func getStorePath(path string) (string, error) {
 		}
 		path = filepath.Join(dir, path)
 	}
+	return path, nil
+}
+
+func getStorePath(path string) (string, error) {
+	path, err := resolveStorePath(path)
+	if err != nil {
+		return "", err
+	}
 
 	stat, err := os.Stat(path)
 	if err != nil {
@@ -200,31 +305,16 @@ func (ca *SelfSignCA) load() error {
 	if keyDERBlock == nil {
 		return fmt.Errorf("%v 中不存在 PRIVATE KEY", caFile)
 	}
-	certDERBlock, _ := pem.Decode(data)
+	certDERBlock, data := pem.Decode(data)
 	if certDERBlock == nil {
 		return fmt.Errorf("%v 中不存在 CERTIFICATE", caFile)
 	}
 
-	var privateKey *rsa.PrivateKey
-	key, err := x509.ParsePKCS8PrivateKey(keyDERBlock.Bytes)
+	privateKey, err := parsePrivateKey(keyDERBlock.Bytes)
 	if err != nil {
-		// fix #14
-		if strings.Contains(err.Error(), "use ParsePKCS1PrivateKey instead") {
-			privateKey, err = x509.ParsePKCS1PrivateKey(keyDERBlock.Bytes)
-			if err != nil {
-				return err
-			}
-		} else {
-			return err
-		}
-	} else {
-		if v, ok := key.(*rsa.PrivateKey); ok {
-			privateKey = v
-		} else {
-			return errors.New("found unknown rsa private key type in PKCS#8 wrapping")
-		}
+		return err
 	}
-	ca.PrivateKey = *privateKey
+	ca.setKey(privateKey)
 
 	x509Cert, err := x509.ParseCertificate(certDERBlock.Bytes)
 	if err != nil {
@@ -232,16 +322,48 @@ func (ca *SelfSignCA) load() error {
 	}
 	ca.RootCert = *x509Cert
 
+	// issuers of intermediate ca
+	ca.Chain = nil
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			break
+		}
+		issuer, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return err
+		}
+		ca.Chain = append(ca.Chain, issuer)
+	}
+
 	return nil
 }
 
-func (ca *SelfSignCA) create() error {
-	key, cert, err := createCert()
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		// fix #14
+		if strings.Contains(err.Error(), "use ParsePKCS1PrivateKey instead") {
+			return x509.ParsePKCS1PrivateKey(der)
+		}
+		if strings.Contains(err.Error(), "use ParseECPrivateKey instead") {
+			return x509.ParseECPrivateKey(der)
+		}
+		return nil, err
+	}
+	if v, ok := key.(crypto.Signer); ok {
+		return v, nil
+	}
+	return nil, errors.New("found unknown private key type in PKCS#8 wrapping")
+}
+
+func (ca *SelfSignCA) create(keyType KeyType, validity time.Duration) error {
+	key, cert, err := createCert(keyType, validity)
 	if err != nil {
 		return err
 	}
 
-	ca.PrivateKey = *key
+	ca.setKey(key)
 	ca.RootCert = *cert
 
 	if err := ca.save(); err != nil {
@@ -250,8 +372,30 @@ func (ca *SelfSignCA) create() error {
 	return ca.saveCert()
 }
 
+// setKey set Signer, and PrivateKey if it's rsa
+func (ca *SelfSignCA) setKey(key crypto.Signer) {
+	ca.Signer = key
+	if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+		ca.PrivateKey = *rsaKey
+	} else {
+		ca.PrivateKey = rsa.PrivateKey{}
+	}
+}
+
+// signer the key of RootCert, PrivateKey if Signer is not set by the caller
+func (ca *SelfSignCA) signer() crypto.Signer {
+	if ca.Signer != nil {
+		return ca.Signer
+	}
+	return &ca.PrivateKey
+}
+
+func (ca *SelfSignCA) leafKeyShared() bool {
+	return ca.LeafKeyType == "" || ca.LeafKeyType == LeafKeyShared
+}
+
 func (ca *SelfSignCA) saveTo(out io.Writer) error {
-	keyBytes, err := x509.MarshalPKCS8PrivateKey(&ca.PrivateKey)
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(ca.signer())
 	if err != nil {
 		return err
 	}
@@ -260,11 +404,20 @@ func (ca *SelfSignCA) saveTo(out io.Writer) error {
 		return err
 	}
 
-	return pem.Encode(out, &pem.Block{Type: "CERTIFICATE", Bytes: ca.RootCert.Raw})
+	err = pem.Encode(out, &pem.Block{Type: "CERTIFICATE", Bytes: ca.RootCert.Raw})
+	if err != nil {
+		return err
+	}
+	for _, issuer := range ca.Chain {
+		if err := pem.Encode(out, &pem.Block{Type: "CERTIFICATE", Bytes: issuer.Raw}); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (ca *SelfSignCA) saveCertTo(out io.Writer) error {
-	return pem.Encode(out, &pem.Block{Type: "CERTIFICATE", Bytes: ca.RootCert.Raw})
+	return pem.Encode(out, &pem.Block{Type: "CERTIFICATE", Bytes: ca.GetRootCA().Raw})
 }
 
 func (ca *SelfSignCA) save() error {
@@ -299,27 +452,57 @@ func (ca *SelfSignCA) saveCert() error {
 	return err
 }
 
+// GetRootCA the root of Chain if RootCert is an intermediate ca
 func (ca *SelfSignCA) GetRootCA() *x509.Certificate {
+	if len(ca.Chain) > 0 {
+		return ca.Chain[len(ca.Chain)-1]
+	}
 	return &ca.RootCert
 }
 
+// certificates sent with the leaf, the root is not included
+func (ca *SelfSignCA) leafChain(leaf []byte) [][]byte {
+	certs := [][]byte{leaf}
+	if len(ca.Chain) == 0 {
+		return certs
+	}
+	certs = append(certs, ca.RootCert.Raw)
+	for _, issuer := range ca.Chain[:len(ca.Chain)-1] {
+		certs = append(certs, issuer.Raw)
+	}
+	return certs
+}
+
 func (ca *SelfSignCA) GetCert(commonName string) (*tls.Certificate, error) {
+	return ca.GetCertForUpstream(commonName, nil)
+}
+
+// GetCertForUpstream 生成与上游证书相同 SubjectAltName 和 Subject 的证书，upstream 为 nil 时只包含 commonName
+func (ca *SelfSignCA) GetCertForUpstream(commonName string, upstream *x509.Certificate) (*tls.Certificate, error) {
+	subject, names := ca.leafSubject(commonName, upstream)
+	key := subject.String() + "|" + strings.Join(names, ",")
+
 	ca.cacheMu.Lock()
-	if val, ok := ca.cache.Get(commonName); ok {
+	if val, ok := ca.cache.Get(key); ok {
 		ca.cacheMu.Unlock()
-		log.Debugf("ca GetCert: %v", commonName)
+		log.Debugf("ca GetCert: %v", key)
 		return val.(*tls.Certificate), nil
 	}
 	ca.cacheMu.Unlock()
 
-	val, err := ca.group.Do(commonName, func() (interface{}, error) {
-		cert, err := ca.DummyCert(commonName)
-		if err == nil {
-			ca.cacheMu.Lock()
-			ca.cache.Add(commonName, cert)
-			ca.cacheMu.Unlock()
+	val, err := ca.group.Do(key, func() (interface{}, error) {
+		cert := ca.loadDiskCache(key)
+		if cert == nil {
+			var err error
+			if cert, err = ca.dummyCert(subject, names); err != nil {
+				return nil, err
+			}
+			ca.saveDiskCache(key, cert)
 		}
-		return cert, err
+		ca.cacheMu.Lock()
+		ca.cache.Add(key, cert)
+		ca.cacheMu.Unlock()
+		return cert, nil
 	})
 
 	if err != nil {
@@ -329,36 +512,132 @@ func (ca *SelfSignCA) GetCert(commonName string) (*tls.Certificate, error) {
 	return val.(*tls.Certificate), nil
 }
 
-// TODO: 是否应该支持多个 SubjectAltName
+// Warm generate and cache certificates of the hosts
+func (ca *SelfSignCA) Warm(hosts []string) {
+	for _, host := range hosts {
+		if _, err := ca.GetCert(host); err != nil {
+			log.Warnf("ca warm %v error: %v", host, err)
+		}
+	}
+	log.Debugf("ca warmed %v hosts", len(hosts))
+}
+
+// GetCertForConn implement ConnCA
+func (ca *SelfSignCA) GetCertForConn(chi *tls.ClientHelloInfo, upstream *x509.Certificate) (*tls.Certificate, error) {
+	return ca.GetCertForUpstream(chi.ServerName, upstream)
+}
+
+// subject and sorted SubjectAltName of the leaf certificate
+func (ca *SelfSignCA) leafSubject(commonName string, upstream *x509.Certificate) (pkix.Name, []string) {
+	name := commonName
+	if ca.Wildcard {
+		name = wildcardName(commonName)
+	}
+	subject := pkix.Name{
+		CommonName:   name,
+		Organization: []string{"mitmproxy"},
+	}
+	names := []string{name}
+	if upstream == nil {
+		return subject, names
+	}
+
+	if upstream.Subject.CommonName != "" {
+		subject = upstream.Subject
+		subject.Names = nil
+	} else if len(upstream.Subject.Organization) > 0 {
+		subject.Organization = upstream.Subject.Organization
+	}
+	for _, dnsName := range upstream.DNSNames {
+		if ca.Wildcard {
+			dnsName = wildcardName(dnsName)
+		}
+		names = append(names, dnsName)
+	}
+	for _, ip := range upstream.IPAddresses {
+		names = append(names, ip.String())
+	}
+
+	sort.Strings(names)
+	return subject, compactStrings(names)
+}
+
+// a.b.example.com => *.b.example.com, keep the name if the parent is a public suffix such as co.uk, or it's an ip
+func wildcardName(name string) string {
+	if net.ParseIP(name) != nil || strings.HasPrefix(name, "*.") {
+		return name
+	}
+	i := strings.IndexByte(name, '.')
+	if i < 0 {
+		return name
+	}
+	parent := name[i+1:]
+	// error if parent is a public suffix
+	if _, err := publicsuffix.EffectiveTLDPlusOne(parent); err != nil {
+		return name
+	}
+	return "*." + parent
+}
+
+// remove adjacent duplicates of sorted strings
+func compactStrings(strs []string) []string {
+	result := strs[:0]
+	for i, s := range strs {
+		if i > 0 && s == strs[i-1] {
+			continue
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
 func (ca *SelfSignCA) DummyCert(commonName string) (*tls.Certificate, error) {
-	log.Debugf("ca DummyCert: %v", commonName)
+	return ca.dummyCert(pkix.Name{
+		CommonName:   commonName,
+		Organization: []string{"mitmproxy"},
+	}, []string{commonName})
+}
+
+// names could be dns names or ip addresses
+func (ca *SelfSignCA) dummyCert(subject pkix.Name, names []string) (*tls.Certificate, error) {
+	log.Debugf("ca DummyCert: %v %v", subject.CommonName, names)
+	validity := ca.LeafValidity
+	if validity <= 0 {
+		validity = defaultLeafValidity
+	}
+	notBefore := time.Now().Add(-time.Hour * 48)
 	template := &x509.Certificate{
 		SerialNumber: big.NewInt(time.Now().UnixNano() / 100000),
-		Subject: pkix.Name{
-			CommonName:   commonName,
-			Organization: []string{"mitmproxy"},
-		},
-		NotBefore:          time.Now().Add(-time.Hour * 48),
-		NotAfter:           time.Now().Add(time.Hour * 24 * 365),
-		SignatureAlgorithm: x509.SHA256WithRSA,
-		ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		Subject:      subject,
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(validity),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
 	}
 
-	ip := net.ParseIP(commonName)
-	if ip != nil {
-		template.IPAddresses = []net.IP{ip}
-	} else {
-		template.DNSNames = []string{commonName}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+
+	key := ca.signer()
+	if !ca.leafKeyShared() {
+		var err error
+		if key, err = generateKey(ca.LeafKeyType); err != nil {
+			return nil, err
+		}
 	}
 
-	certBytes, err := x509.CreateCertificate(rand.Reader, template, &ca.RootCert, &ca.PrivateKey.PublicKey, &ca.PrivateKey)
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, &ca.RootCert, key.Public(), ca.signer())
 	if err != nil {
 		return nil, err
 	}
 
 	cert := &tls.Certificate{
-		Certificate: [][]byte{certBytes},
-		PrivateKey:  &ca.PrivateKey,
+		Certificate: ca.leafChain(certBytes),
+		PrivateKey:  key,
 	}
 
 	return cert, nil
@@ -2,9 +2,16 @@ package cert
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestGetStorePath(t *testing.T) {
@@ -41,3 +48,280 @@ func TestNewCA(t *testing.T) {
 		t.Fatal("pem content should equal")
 	}
 }
+
+func TestGetCertForUpstream(t *testing.T) {
+	caApi, err := NewSelfSignCAMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := caApi.(*SelfSignCA)
+
+	upstream := &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:   "*.example.com",
+			Organization: []string{"Example Inc"},
+		},
+		DNSNames:    []string{"*.example.com", "example.com"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+	}
+	tlsCert, err := ca.GetCertForUpstream("www.example.com", upstream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.Subject.CommonName != "*.example.com" || !reflect.DeepEqual(leaf.Subject.Organization, []string{"Example Inc"}) {
+		t.Fatalf("unexpected subject %v", leaf.Subject)
+	}
+	if !reflect.DeepEqual(leaf.DNSNames, []string{"*.example.com", "example.com", "www.example.com"}) {
+		t.Fatalf("unexpected dns names %v", leaf.DNSNames)
+	}
+	if len(leaf.IPAddresses) != 1 || !leaf.IPAddresses[0].Equal(net.ParseIP("10.0.0.1")) {
+		t.Fatalf("unexpected ip addresses %v", leaf.IPAddresses)
+	}
+	for _, host := range []string{"www.example.com", "example.com", "api.example.com", "10.0.0.1"} {
+		if err := leaf.VerifyHostname(host); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// cached by subject and names
+	tlsCert2, err := ca.GetCertForUpstream("www.example.com", upstream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsCert2 != tlsCert {
+		t.Fatal("should use cached certificate")
+	}
+}
+
+func TestWildcardCert(t *testing.T) {
+	caApi, err := NewSelfSignCAMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := caApi.(*SelfSignCA)
+	ca.Wildcard = true
+
+	cert1, err := ca.GetCert("a.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert2, err := ca.GetCert("b.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert1 != cert2 {
+		t.Fatal("subdomains should share the wildcard certificate")
+	}
+	leaf, err := x509.ParseCertificate(cert1.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(leaf.DNSNames, []string{"*.example.com"}) {
+		t.Fatalf("unexpected dns names %v", leaf.DNSNames)
+	}
+
+	for name, expected := range map[string]string{
+		"example.com":     "example.com",
+		"localhost":       "localhost",
+		"127.0.0.1":       "127.0.0.1",
+		"a.b.example.com": "*.b.example.com",
+		"example.co.uk":   "example.co.uk",
+		"a.example.co.uk": "*.example.co.uk",
+		"foo.github.io":   "foo.github.io",
+	} {
+		if got := wildcardName(name); got != expected {
+			t.Fatalf("wildcardName(%v) expected %v, got %v", name, expected, got)
+		}
+	}
+}
+
+func TestKeyTypes(t *testing.T) {
+	for _, keyType := range []KeyType{KeyTypeRSA, KeyTypeECDSAP256, KeyTypeECDSAP384, KeyTypeEd25519} {
+		t.Run(string(keyType), func(t *testing.T) {
+			caApi, err := NewSelfSignCAMemoryWithOptions(&SelfSignCAOptions{
+				RootKeyType: keyType,
+				LeafKeyType: KeyTypeECDSAP256,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ca := caApi.(*SelfSignCA)
+
+			tlsCert, err := ca.GetCert("example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			leaf, err := x509.ParseCertificate(tlsCert.Certificate[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if leaf.PublicKeyAlgorithm != x509.ECDSA {
+				t.Fatalf("expected ecdsa leaf key, got %v", leaf.PublicKeyAlgorithm)
+			}
+			if reflect.DeepEqual(tlsCert.PrivateKey, ca.Signer) {
+				t.Fatal("leaf should not share the root ca key")
+			}
+			if err := leaf.CheckSignatureFrom(ca.GetRootCA()); err != nil {
+				t.Fatal(err)
+			}
+			if validity := leaf.NotAfter.Sub(leaf.NotBefore); validity > time.Hour*24*398 {
+				t.Fatalf("leaf validity %v exceeds 398 days", validity)
+			}
+
+			// saved key could be loaded
+			buf := bytes.NewBuffer(nil)
+			if err := ca.saveTo(buf); err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			if err := ioutil.WriteFile(filepath.Join(dir, "mitmproxy-ca.pem"), buf.Bytes(), 0600); err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := NewSelfSignCA(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !loaded.GetRootCA().Equal(ca.GetRootCA()) {
+				t.Fatal("loaded root ca should equal")
+			}
+		})
+	}
+
+	if _, err := ParseKeyType("dsa"); err == nil {
+		t.Fatal("expected invalid key type error")
+	}
+	if _, err := ParseKeyType("shared"); err == nil {
+		t.Fatal("root key should not be shared")
+	}
+	for s, expected := range map[string]KeyType{"": KeyTypeECDSAP256, "shared": LeafKeyShared, "rsa": KeyTypeRSA} {
+		if keyType, err := ParseLeafKeyType(s); err != nil || keyType != expected {
+			t.Fatalf("ParseLeafKeyType(%q) expected %v, got %v %v", s, expected, keyType, err)
+		}
+	}
+}
+
+func TestSharedLeafKey(t *testing.T) {
+	// per-leaf key by default
+	caApi, err := NewSelfSignCAMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsCert, err := caApi.GetCert("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tlsCert.PrivateKey.(*ecdsa.PrivateKey); !ok || tlsCert.PrivateKey == caApi.(*SelfSignCA).Signer {
+		t.Fatal("leaf should have its own ecdsa key by default")
+	}
+
+	caApi, err = NewSelfSignCAMemoryWithOptions(&SelfSignCAOptions{LeafKeyType: LeafKeyShared, LeafValidity: time.Hour * 24 * 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := caApi.(*SelfSignCA)
+	tlsCert, err = ca.GetCert("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsCert.PrivateKey != ca.Signer {
+		t.Fatal("leaf should share the root ca key")
+	}
+	leaf, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if validity := leaf.NotAfter.Sub(leaf.NotBefore); validity != time.Hour*24*30 {
+		t.Fatalf("expected validity of 30 days, got %v", validity)
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	dir := t.TempDir()
+	opts := &SelfSignCAOptions{DiskCache: true, LeafKeyType: KeyTypeECDSAP256}
+	caApi, err := NewSelfSignCAWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsCert, err := caApi.GetCert("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// loaded from disk after restart
+	caApi2, err := NewSelfSignCAWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsCert2, err := caApi2.GetCert("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tlsCert.Certificate[0], tlsCert2.Certificate[0]) || !reflect.DeepEqual(tlsCert.PrivateKey, tlsCert2.PrivateKey) {
+		t.Fatal("should load certificate from disk cache")
+	}
+
+	// invalidated after root ca changed
+	oldCacheDir := caApi.(*SelfSignCA).diskCacheDir()
+	if err := os.Remove(caApi.(*SelfSignCA).caFile()); err != nil {
+		t.Fatal(err)
+	}
+	caApi3, err := NewSelfSignCAWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(oldCacheDir); !os.IsNotExist(err) {
+		t.Fatal("disk cache of old root ca should be removed")
+	}
+	tlsCert3, err := caApi3.GetCert("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(tlsCert3.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.CheckSignatureFrom(caApi3.GetRootCA()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWarm(t *testing.T) {
+	caApi, err := NewSelfSignCAMemoryWithOptions(&SelfSignCAOptions{CacheSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := caApi.(*SelfSignCA)
+	ca.Warm([]string{"a.example.com", "b.example.com", "c.example.com"})
+	if n := ca.cache.Len(); n != 2 {
+		t.Fatalf("expected 2 cached certificates, got %v", n)
+	}
+}
+
+func TestRsaPrivateKeyCompatible(t *testing.T) {
+	caApi, err := NewSelfSignCAMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := caApi.(*SelfSignCA)
+	if ca.PrivateKey.N == nil {
+		t.Fatal("PrivateKey should be set for rsa root ca")
+	}
+
+	// set by the caller without Signer
+	old := &SelfSignCA{PrivateKey: ca.PrivateKey, RootCert: ca.RootCert}
+	tlsCert, err := old.DummyCert("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.CheckSignatureFrom(&ca.RootCert); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/cert"
+	log "github.com/sirupsen/logrus"
+)
+
+const caUsage = `Usage: go-mitmproxy ca <command> [flags]
+
+Commands:
+  export        export the root ca in pem, der or p12 format
+  fingerprint   print the fingerprints of the root ca
+  rotate        replace the ca by a new root ca
+  import        import an existing certificate and private key as the ca
+  intermediate  sign an intermediate ca by the ca of root_path, and use it as the ca
+
+Run go-mitmproxy ca <command> -h for the flags of the command.
+`
+
+// manage the ca stored in cert_path
+func caMain(args []string) {
+	setupLog(0)
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, caUsage)
+		os.Exit(2)
+	}
+
+	command, args := args[0], args[1:]
+	flags := flag.NewFlagSet("ca "+command, flag.ExitOnError)
+	certPath := flags.String("cert_path", "", "path of generate cert files")
+
+	switch command {
+	case "export":
+		format := flags.String("format", cert.FormatPEM, "format: pem, der, p12")
+		withKey := flags.Bool("key", false, "also export the private key and certificate chain, for pem and p12")
+		password := flags.String("password", "", "password of p12")
+		out := flags.String("out", "", "output filename, default stdout")
+		flags.Parse(args)
+		data, err := loadSelfSignCA(*certPath).Export(*format, *withKey, *password)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *out == "" {
+			os.Stdout.Write(data)
+			return
+		}
+		mode := os.FileMode(0644)
+		if *withKey {
+			mode = 0600
+		}
+		if err := os.WriteFile(*out, data, mode); err != nil {
+			log.Fatal(err)
+		}
+
+	case "fingerprint":
+		flags.Parse(args)
+		printFingerprint(loadSelfSignCA(*certPath))
+
+	case "rotate":
+		keyType := flags.String("key_type", "", "key type of the new root ca: rsa, ecdsa-p256, ecdsa-p384, ed25519, default rsa")
+		days := flags.Int("days", 0, "validity days of the new root ca, default 1095")
+		backup := flags.Bool("backup", true, "backup the old ca to cert_path before rotating")
+		flags.Parse(args)
+		kt, err := cert.ParseKeyType(*keyType)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ca := loadSelfSignCA(*certPath)
+		if *backup {
+			data, err := ca.Export(cert.FormatPEM, true, "")
+			if err != nil {
+				log.Fatal(err)
+			}
+			filename := filepath.Join(ca.StorePath, fmt.Sprintf("mitmproxy-ca-%v.pem.bak", time.Now().Unix()))
+			if err := os.WriteFile(filename, data, 0600); err != nil {
+				log.Fatal(err)
+			}
+			log.Infof("old ca saved to %v", filename)
+		}
+		if err := ca.Rotate(kt, time.Hour*24*time.Duration(*days)); err != nil {
+			log.Fatal(err)
+		}
+		log.Info("ca rotated, install the new root ca on clients")
+		printFingerprint(ca)
+
+	case "import":
+		certFile := flags.String("cert", "", "PEM file of the ca certificate, followed by its issuers if it's an intermediate ca")
+		keyFile := flags.String("key", "", "PEM file of the private key, default read from the cert file")
+		flags.Parse(args)
+		if *certFile == "" {
+			flags.Usage()
+			os.Exit(2)
+		}
+		certPEM, err := os.ReadFile(*certFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var keyPEM []byte
+		if *keyFile != "" {
+			if keyPEM, err = os.ReadFile(*keyFile); err != nil {
+				log.Fatal(err)
+			}
+		}
+		key, chain, err := cert.ParseKeyPair(certPEM, keyPEM)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := cert.SaveSelfSignCA(*certPath, key, chain); err != nil {
+			log.Fatal(err)
+		}
+		log.Info("ca imported")
+		printFingerprint(loadSelfSignCA(*certPath))
+
+	case "intermediate":
+		rootPath := flags.String("root_path", "", "path of the root ca, such as an offline disk")
+		keyType := flags.String("key_type", "", "key type of the intermediate ca: rsa, ecdsa-p256, ecdsa-p384, ed25519, default rsa")
+		days := flags.Int("days", 0, "validity days of the intermediate ca, default 1095")
+		flags.Parse(args)
+		if *rootPath == "" {
+			flags.Usage()
+			os.Exit(2)
+		}
+		kt, err := cert.ParseKeyType(*keyType)
+		if err != nil {
+			log.Fatal(err)
+		}
+		root := loadSelfSignCA(*rootPath)
+		key, chain, err := root.SignIntermediate(kt, time.Hour*24*time.Duration(*days))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := cert.SaveSelfSignCA(*certPath, key, chain); err != nil {
+			log.Fatal(err)
+		}
+		log.Info("intermediate ca saved, clients should trust the root ca")
+		printFingerprint(loadSelfSignCA(*certPath))
+
+	default:
+		fmt.Fprint(os.Stderr, caUsage)
+		os.Exit(2)
+	}
+}
+
+// load the existing ca, not create new ca for a mistyped path
+func loadSelfSignCA(path string) *cert.SelfSignCA {
+	ca, err := cert.LoadSelfSignCA(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return ca
+}
+
+func printFingerprint(ca *cert.SelfSignCA) {
+	root := ca.GetRootCA()
+	fmt.Printf("Subject: %v\n", root.Subject)
+	fmt.Printf("NotAfter: %v\n", root.NotAfter.Format(time.RFC3339))
+	fmt.Printf("SHA256 Fingerprint: %v\n", cert.Fingerprint(root))
+	fmt.Printf("SHA1 Fingerprint: %v\n", cert.FingerprintSHA1(root))
+	if len(ca.Chain) > 0 {
+		fmt.Printf("Intermediate Subject: %v\n", ca.RootCert.Subject)
+		fmt.Printf("Intermediate SHA256 Fingerprint: %v\n", cert.Fingerprint(&ca.RootCert))
+	}
+}
@@ -26,14 +26,39 @@ func loadConfigFromCli() *Config {
 	flag.Var((*arrayValue)(&config.IgnoreHosts), "ignore_hosts", "a list of ignore hosts")
 	flag.Var((*arrayValue)(&config.AllowHosts), "allow_hosts", "a list of allow hosts")
 	flag.StringVar(&config.CertPath, "cert_path", "", "path of generate cert files")
+	flag.BoolVar(&config.CertWildcard, "cert_wildcard", false, "issue *.parent wildcard certificates to reduce certificates generated")
+	flag.StringVar(&config.CertKeyType, "cert_key_type", "", "key type of new root ca: rsa, ecdsa-p256, ecdsa-p384, ed25519, default rsa")
+	flag.IntVar(&config.CertRootDays, "cert_root_days", 0, "validity days of new root ca, default 1095")
+	flag.StringVar(&config.CertLeafKey, "cert_leaf_key_type", "", "key type generated for each leaf certificate, default ecdsa-p256, shared to use the root ca key")
+	flag.IntVar(&config.CertLeafDays, "cert_leaf_days", 0, "validity days of leaf certificates, default 397")
+	flag.IntVar(&config.CertCacheSize, "cert_cache_size", 0, "max leaf certificates cached in memory, default 100")
+	flag.BoolVar(&config.CertDiskCache, "cert_disk_cache", false, "cache leaf certificates under cert_path, invalidated when root ca changed")
+	flag.StringVar(&config.CertWarm, "cert_warm", "", "filename of hosts, one per line, to generate certificates after startup")
+	flag.StringVar(&config.ClientAuth, "client_auth", "", "request client certificate when intercepting: request, require")
 	flag.IntVar(&config.Debug, "debug", 0, "debug mode: 1 - print debug log, 2 - show debug from")
 	flag.StringVar(&config.Dump, "dump", "", "dump filename")
 	flag.IntVar(&config.DumpLevel, "dump_level", 0, "dump level: 0 - header, 1 - header + body")
 	flag.StringVar(&config.Upstream, "upstream", "", "upstream proxy")
+	flag.StringVar(&config.ClientCerts, "upstream_client_certs", "", "upstream client certificates config filename of mutual tls, json or yaml")
 	flag.BoolVar(&config.UpstreamCert, "upstream_cert", true, "connect to upstream server to look up certificate details")
+	flag.Var((*arrayValue)(&config.UpstreamCAs), "upstream_root_cas", "PEM files of extra root CAs to verify upstream certificates")
+	flag.StringVar(&config.VerifyRules, "upstream_verify", "", "upstream verification rules config filename of insecure hosts and pins, json or yaml")
 	flag.StringVar(&config.MapRemote, "map_remote", "", "map remote config filename")
 	flag.StringVar(&config.MapLocal, "map_local", "", "map local config filename")
+	flag.StringVar(&config.ModifyHeaders, "modify_headers", "", "modify headers config filename, json or yaml")
+	flag.StringVar(&config.ModifyBody, "modify_body", "", "modify body config filename, json or yaml")
 	flag.StringVar(&config.LogFile, "log_file", "", "log file path")
+	flag.StringVar(&config.Mode, "mode", "", "proxy mode: regular, transparent, reverse:https://backend:8443")
+	flag.StringVar(&config.SocksAddr, "socks_addr", "", "socks4/4a/5 listen addr, could be the same as addr")
+	flag.StringVar(&config.Http3Addr, "http3_addr", "", "http3 (quic) udp listen addr")
+	flag.BoolVar(&config.Http3Upstream, "http3_upstream", false, "forward http3 requests to upstream over http3 instead of http2")
+	flag.BoolVar(&config.StripAltSvc, "strip_alt_svc", false, "remove Alt-Svc header from responses, keep clients on tcp")
+	flag.IntVar(&config.Passthrough, "tls_passthrough", 0, "passthrough the host after client rejected the certificate of proxy the times, 0 to disable")
+	flag.StringVar(&config.PassHostsFile, "tls_passthrough_file", "", "filename to persist the learned passthrough hosts")
+	flag.StringVar(&config.UpstreamHello, "upstream_client_hello", "", "ClientHello sent to upstream: mimic the client, or browser profile chrome, firefox, safari, edge, ios")
+	flag.StringVar(&config.Har, "har", "", "har filename, flows are saved when go-mitmproxy exits")
+	flag.StringVar(&config.ServerReplay, "server_replay", "", "server replay config filename")
+	flag.StringVar(&config.Script, "script", "", "javascript filename of addon hooks, reloaded when changed")
 	flag.StringVar(&config.filename, "f", "", "read config from the filename")
 
 	flag.StringVar(&config.ProxyAuth, "proxyauth", "", `enable proxy authentication. Format: "username:pass", "user1:pass1|user2:pass2","any" to accept any user/pass combination`)
@@ -63,6 +88,30 @@ func mergeConfigs(fileConfig, cliConfig *Config) *Config {
 	if cliConfig.CertPath != "" {
 		config.CertPath = cliConfig.CertPath
 	}
+	if cliConfig.CertWildcard {
+		config.CertWildcard = cliConfig.CertWildcard
+	}
+	if cliConfig.CertKeyType != "" {
+		config.CertKeyType = cliConfig.CertKeyType
+	}
+	if cliConfig.CertRootDays != 0 {
+		config.CertRootDays = cliConfig.CertRootDays
+	}
+	if cliConfig.CertLeafKey != "" {
+		config.CertLeafKey = cliConfig.CertLeafKey
+	}
+	if cliConfig.CertLeafDays != 0 {
+		config.CertLeafDays = cliConfig.CertLeafDays
+	}
+	if cliConfig.CertCacheSize != 0 {
+		config.CertCacheSize = cliConfig.CertCacheSize
+	}
+	if cliConfig.CertDiskCache {
+		config.CertDiskCache = cliConfig.CertDiskCache
+	}
+	if cliConfig.CertWarm != "" {
+		config.CertWarm = cliConfig.CertWarm
+	}
 	if cliConfig.Debug != 0 {
 		config.Debug = cliConfig.Debug
 	}
@@ -75,6 +124,18 @@ func mergeConfigs(fileConfig, cliConfig *Config) *Config {
 	if cliConfig.Upstream != "" {
 		config.Upstream = cliConfig.Upstream
 	}
+	if cliConfig.ClientAuth != "" {
+		config.ClientAuth = cliConfig.ClientAuth
+	}
+	if cliConfig.ClientCerts != "" {
+		config.ClientCerts = cliConfig.ClientCerts
+	}
+	if len(cliConfig.UpstreamCAs) > 0 {
+		config.UpstreamCAs = cliConfig.UpstreamCAs
+	}
+	if cliConfig.VerifyRules != "" {
+		config.VerifyRules = cliConfig.VerifyRules
+	}
 	if !cliConfig.UpstreamCert {
 		config.UpstreamCert = cliConfig.UpstreamCert
 	}
@@ -84,9 +145,48 @@ func mergeConfigs(fileConfig, cliConfig *Config) *Config {
 	if cliConfig.MapLocal != "" {
 		config.MapLocal = cliConfig.MapLocal
 	}
+	if cliConfig.ModifyHeaders != "" {
+		config.ModifyHeaders = cliConfig.ModifyHeaders
+	}
+	if cliConfig.ModifyBody != "" {
+		config.ModifyBody = cliConfig.ModifyBody
+	}
 	if cliConfig.LogFile != "" {
 		config.LogFile = cliConfig.LogFile
 	}
+	if cliConfig.Mode != "" {
+		config.Mode = cliConfig.Mode
+	}
+	if cliConfig.SocksAddr != "" {
+		config.SocksAddr = cliConfig.SocksAddr
+	}
+	if cliConfig.Http3Addr != "" {
+		config.Http3Addr = cliConfig.Http3Addr
+	}
+	if cliConfig.Http3Upstream {
+		config.Http3Upstream = cliConfig.Http3Upstream
+	}
+	if cliConfig.StripAltSvc {
+		config.StripAltSvc = cliConfig.StripAltSvc
+	}
+	if cliConfig.Passthrough != 0 {
+		config.Passthrough = cliConfig.Passthrough
+	}
+	if cliConfig.PassHostsFile != "" {
+		config.PassHostsFile = cliConfig.PassHostsFile
+	}
+	if cliConfig.UpstreamHello != "" {
+		config.UpstreamHello = cliConfig.UpstreamHello
+	}
+	if cliConfig.Har != "" {
+		config.Har = cliConfig.Har
+	}
+	if cliConfig.ServerReplay != "" {
+		config.ServerReplay = cliConfig.ServerReplay
+	}
+	if cliConfig.Script != "" {
+		config.Script = cliConfig.Script
+	}
 	return config
 }
 
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	rawLog "log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/lqqyt2423/go-mitmproxy/addon"
+	"github.com/lqqyt2423/go-mitmproxy/cert"
 	"github.com/lqqyt2423/go-mitmproxy/internal/helper"
 	"github.com/lqqyt2423/go-mitmproxy/proxy"
 	"github.com/lqqyt2423/go-mitmproxy/web"
@@ -17,20 +22,45 @@ import (
 type Config struct {
 	version bool // show go-mitmproxy version
 
-	Addr         string   // proxy listen addr
-	WebAddr      string   // web interface listen addr
-	SslInsecure  bool     // not verify upstream server SSL/TLS certificates.
-	IgnoreHosts  []string // a list of ignore hosts
-	AllowHosts   []string // a list of allow hosts
-	CertPath     string   // path of generate cert files
-	Debug        int      // debug mode: 1 - print debug log, 2 - show debug from
-	Dump         string   // dump filename
-	DumpLevel    int      // dump level: 0 - header, 1 - header + body
-	Upstream     string   // upstream proxy
-	UpstreamCert bool     // Connect to upstream server to look up certificate details. Default: True
-	MapRemote    string   // map remote config filename
-	MapLocal     string   // map local config filename
-	LogFile      string   // log file path
+	Addr          string   // proxy listen addr
+	WebAddr       string   // web interface listen addr
+	SslInsecure   bool     // not verify upstream server SSL/TLS certificates.
+	IgnoreHosts   []string // a list of ignore hosts
+	AllowHosts    []string // a list of allow hosts
+	CertPath      string   // path of generate cert files
+	CertWildcard  bool     // issue *.parent wildcard certificates
+	CertKeyType   string   // key type of new root ca: rsa, ecdsa-p256, ecdsa-p384, ed25519
+	CertRootDays  int      // validity days of new root ca
+	CertLeafKey   string   // key type generated for each leaf certificate, default ecdsa-p256, shared to use the root ca key
+	CertLeafDays  int      // validity days of leaf certificates
+	CertCacheSize int      // max leaf certificates cached in memory
+	CertDiskCache bool     // cache leaf certificates under cert path
+	CertWarm      string   // filename of hosts to generate certificates after startup
+	Debug         int      // debug mode: 1 - print debug log, 2 - show debug from
+	Dump          string   // dump filename
+	DumpLevel     int      // dump level: 0 - header, 1 - header + body
+	Upstream      string   // upstream proxy
+	ClientCerts   string   // upstream client certificates config filename
+	ClientAuth    string   // request client certificate: request, require
+	UpstreamCAs   []string // PEM files of extra root CAs to verify upstream certificates
+	VerifyRules   string   // upstream verification rules config filename
+	UpstreamCert  bool     // Connect to upstream server to look up certificate details. Default: True
+	MapRemote     string   // map remote config filename
+	MapLocal      string   // map local config filename
+	ModifyHeaders string   // modify headers config filename
+	ModifyBody    string   // modify body config filename
+	LogFile       string   // log file path
+	Mode          string   // proxy mode: regular, transparent, reverse:https://backend:8443
+	SocksAddr     string   // socks4/4a/5 listen addr
+	Http3Addr     string   // http3 (quic) udp listen addr
+	Http3Upstream bool     // forward http3 requests to upstream over http3
+	StripAltSvc   bool     // remove Alt-Svc header from responses
+	Passthrough   int      // passthrough hosts after client tls handshake failed times, 0 to disable
+	PassHostsFile string   // persist the learned passthrough hosts
+	UpstreamHello string   // ClientHello sent to upstream: mimic or browser profile
+	Har           string   // har filename, flushed on shutdown
+	ServerReplay  string   // server replay config filename
+	Script        string   // javascript filename, reloaded when changed
 
 	filename string // read config from the filename
 
@@ -39,21 +69,24 @@ type Config struct {
 }
 
 func main() {
+	// go-mitmproxy replay [flags] <file>
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replayMain(os.Args[2:])
+		return
+	}
+	// go-mitmproxy ca <command> [flags]
+	if len(os.Args) > 1 && os.Args[1] == "ca" {
+		caMain(os.Args[2:])
+		return
+	}
+
 	config := loadConfig()
+	setupLog(config.Debug)
 
-	if config.Debug > 0 {
-		rawLog.SetFlags(rawLog.LstdFlags | rawLog.Lshortfile)
-		log.SetLevel(log.DebugLevel)
-	} else {
-		log.SetLevel(log.InfoLevel)
-	}
-	if config.Debug == 2 {
-		log.SetReportCaller(true)
+	caOptions, err := newCaOptions(config)
+	if err != nil {
+		log.Fatal(err)
 	}
-	log.SetOutput(os.Stdout)
-	log.SetFormatter(&log.TextFormatter{
-		FullTimestamp: true,
-	})
 
 	opts := &proxy.Options{
 		Debug:             config.Debug,
@@ -61,8 +94,48 @@ func main() {
 		StreamLargeBodies: 1024 * 1024 * 5,
 		SslInsecure:       config.SslInsecure,
 		CaRootPath:        config.CertPath,
+		CertWildcard:      config.CertWildcard,
+		CaOptions:         caOptions,
 		Upstream:          config.Upstream,
 		LogFilePath:       config.LogFile,
+		Mode:              config.Mode,
+		SocksAddr:         config.SocksAddr,
+		Http3Addr:         config.Http3Addr,
+		Http3Upstream:     config.Http3Upstream,
+	}
+	opts.PassthroughFailures = config.Passthrough
+	opts.PassthroughFile = config.PassHostsFile
+	opts.UpstreamClientHello = config.UpstreamHello
+
+	switch config.ClientAuth {
+	case "":
+	case "request":
+		opts.ClientAuth = tls.RequestClientCert
+	case "require":
+		opts.ClientAuth = tls.RequireAnyClientCert
+	default:
+		log.Fatalf("invalid client_auth %v, should be request or require", config.ClientAuth)
+	}
+
+	if config.ClientCerts != "" {
+		opts.UpstreamClientCerts, err = proxy.LoadUpstreamClientCerts(config.ClientCerts)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if len(config.UpstreamCAs) > 0 {
+		opts.UpstreamRootCAs, err = proxy.LoadUpstreamRootCAs(config.UpstreamCAs...)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if config.VerifyRules != "" {
+		opts.UpstreamVerifyRules, err = proxy.LoadUpstreamVerifyRules(config.VerifyRules)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	p, err := proxy.NewProxy(opts)
@@ -107,7 +180,14 @@ func main() {
 		// Use default logger
 		p.AddAddon(&proxy.LogAddon{})
 	}
-	p.AddAddon(web.NewWebAddon(config.WebAddr))
+	webAddon := web.NewWebAddon(config.WebAddr)
+	webAddon.SetTlsPassthrough(p.TlsPassthrough())
+	webAddon.SetProxy(p)
+	p.AddAddon(webAddon)
+
+	if config.StripAltSvc {
+		p.AddAddon(&addon.StripAltSvc{})
+	}
 
 	if config.MapRemote != "" {
 		mapRemote, err := addon.NewMapRemoteFromFile(config.MapRemote)
@@ -127,10 +207,130 @@ func main() {
 		}
 	}
 
+	if config.ModifyHeaders != "" {
+		modifyHeaders, err := addon.NewModifyHeadersFromFile(config.ModifyHeaders)
+		if err != nil {
+			log.Warnf("load modify headers error: %v", err)
+		} else {
+			p.AddAddon(modifyHeaders)
+		}
+	}
+
+	if config.ModifyBody != "" {
+		modifyBody, err := addon.NewModifyBodyFromFile(config.ModifyBody)
+		if err != nil {
+			log.Warnf("load modify body error: %v", err)
+		} else {
+			p.AddAddon(modifyBody)
+		}
+	}
+
+	if config.ServerReplay != "" {
+		serverReplay, err := addon.NewServerReplayFromFile(config.ServerReplay)
+		if err != nil {
+			log.Warnf("load server replay error: %v", err)
+		} else {
+			p.AddAddon(serverReplay)
+		}
+	}
+
+	var script *addon.Script
+	if config.Script != "" {
+		script, err = addon.NewScript(config.Script)
+		if err != nil {
+			log.Warnf("load script error: %v", err)
+		} else {
+			p.AddAddon(script)
+		}
+	}
+
 	if config.Dump != "" {
 		dumper := addon.NewDumperWithFilename(config.Dump, config.DumpLevel)
 		p.AddAddon(dumper)
 	}
 
-	log.Fatal(p.Start())
+	var harExporter *addon.HarExporter
+	if config.Har != "" {
+		harExporter = addon.NewHarExporter(config.Har, p.Version)
+		p.AddAddon(harExporter)
+	}
+
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		<-sigs
+		log.Infoln("Shutting down")
+		p.Close()
+	}()
+
+	err = p.Start()
+	if script != nil {
+		script.Close()
+	}
+	if harExporter != nil {
+		if err := harExporter.Flush(); err != nil {
+			log.Errorf("write har %v error: %v", config.Har, err)
+		} else {
+			log.Infof("Har saved to %v", config.Har)
+		}
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+func setupLog(debug int) {
+	if debug > 0 {
+		rawLog.SetFlags(rawLog.LstdFlags | rawLog.Lshortfile)
+		log.SetLevel(log.DebugLevel)
+	} else {
+		log.SetLevel(log.InfoLevel)
+	}
+	if debug == 2 {
+		log.SetReportCaller(true)
+	}
+	log.SetOutput(os.Stdout)
+	log.SetFormatter(&log.TextFormatter{
+		FullTimestamp: true,
+	})
+}
+
+func newCaOptions(config *Config) (*cert.SelfSignCAOptions, error) {
+	rootKeyType, err := cert.ParseKeyType(config.CertKeyType)
+	if err != nil {
+		return nil, err
+	}
+	opts := &cert.SelfSignCAOptions{
+		RootKeyType:  rootKeyType,
+		RootValidity: time.Hour * 24 * time.Duration(config.CertRootDays),
+		LeafValidity: time.Hour * 24 * time.Duration(config.CertLeafDays),
+		CacheSize:    config.CertCacheSize,
+		DiskCache:    config.CertDiskCache,
+	}
+	if opts.LeafKeyType, err = cert.ParseLeafKeyType(config.CertLeafKey); err != nil {
+		return nil, err
+	}
+	if config.CertWarm != "" {
+		if opts.WarmHosts, err = readHostsFile(config.CertWarm); err != nil {
+			return nil, err
+		}
+	}
+	return opts, nil
+}
+
+// one host per line, skip empty lines and comments start with #
+func readHostsFile(filename string) ([]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	hosts := make([]string, 0)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hosts = append(hosts, line)
+	}
+	return hosts, nil
 }
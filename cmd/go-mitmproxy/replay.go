@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/addon"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+	"github.com/lqqyt2423/go-mitmproxy/web"
+	log "github.com/sirupsen/logrus"
+)
+
+// client replay: resend requests recorded in har file through the proxy
+func replayMain(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: go-mitmproxy replay [flags] <file.har>\n")
+		fmt.Fprintf(flags.Output(), "Requests are replayed through a separate proxy started by this command, not a running go-mitmproxy, unless -proxy_web_addr is set.\n")
+		flags.PrintDefaults()
+	}
+	concurrency := flags.Int("concurrency", 1, "max number of requests in flight")
+	keepTiming := flags.Bool("keep_timing", false, "preserve the original delays between requests")
+	debug := flags.Int("debug", 0, "debug mode: 1 - print debug log, 2 - show debug from")
+	sslInsecure := flags.Bool("ssl_insecure", false, "not verify upstream server SSL/TLS certificates.")
+	upstream := flags.String("upstream", "", "upstream proxy")
+	certPath := flags.String("cert_path", "", "path of generate cert files")
+	dump := flags.String("dump", "", "dump filename")
+	dumpLevel := flags.Int("dump_level", 0, "dump level: 0 - header, 1 - header + body")
+	har := flags.String("har", "", "har filename of replayed flows")
+	webAddr := flags.String("web_addr", "", "web interface listen addr of the separate proxy to view replayed flows, replay starts after pressing Enter")
+	proxyWebAddr := flags.String("proxy_web_addr", "", "web interface addr of a running go-mitmproxy to replay through it, the flags of the separate proxy are ignored")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *concurrency < 1 {
+		*concurrency = 1
+	}
+	setupLog(*debug)
+
+	records, err := addon.ReadHarRecords(flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *proxyWebAddr != "" {
+		replayRecords(records, *concurrency, *keepTiming, func(req *proxy.Request) error {
+			return webReplay(*proxyWebAddr, req)
+		})
+		return
+	}
+
+	p, err := proxy.NewProxy(&proxy.Options{
+		Debug:             *debug,
+		StreamLargeBodies: 1024 * 1024 * 5,
+		SslInsecure:       *sslInsecure,
+		CaRootPath:        *certPath,
+		Upstream:          *upstream,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	p.AddAddon(&proxy.LogAddon{})
+	if *dump != "" {
+		p.AddAddon(addon.NewDumperWithFilename(*dump, *dumpLevel))
+	}
+	var harExporter *addon.HarExporter
+	if *har != "" {
+		harExporter = addon.NewHarExporter(*har, p.Version)
+		p.AddAddon(harExporter)
+	}
+	if *webAddr != "" {
+		webAddon := web.NewWebAddon(*webAddr)
+		webAddon.SetProxy(p)
+		p.AddAddon(webAddon)
+		// flows are pushed to the opened web pages only
+		log.Infof("open the web interface at %v, then press Enter to start replay", *webAddr)
+		bufio.NewReader(os.Stdin).ReadString('\n')
+	}
+
+	replayRecords(records, *concurrency, *keepTiming, func(req *proxy.Request) error {
+		_, err := p.Replay(req)
+		return err
+	})
+
+	if harExporter != nil {
+		if err := harExporter.Flush(); err != nil {
+			log.Fatalf("write har %v error: %v", *har, err)
+		}
+	}
+
+	if *webAddr != "" {
+		log.Info("replay finished, press Ctrl+C to exit")
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		<-sigs
+	}
+}
+
+func replayRecords(records []*addon.HarRecord, concurrency int, keepTiming bool, replay func(req *proxy.Request) error) {
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, record := range records {
+		if keepTiming {
+			delay := record.StartedTime.Sub(records[0].StartedTime)
+			time.Sleep(time.Until(start.Add(delay)))
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(record *addon.HarRecord) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := replay(record.Request); err != nil {
+				log.Errorf("replay %v error: %v", record.Request.URL, err)
+			}
+		}(record)
+	}
+	wg.Wait()
+}
+
+// replay request by /api/replay of the web interface of a running go-mitmproxy
+func webReplay(webAddr string, req *proxy.Request) error {
+	body, err := json.Marshal(&web.ReplayRequest{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Proto:  req.Proto,
+		Header: req.Header,
+		Body:   req.Body,
+	})
+	if err != nil {
+		return err
+	}
+	res, err := http.Post("http://"+webAddr+"/api/replay", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("%v: %s", res.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
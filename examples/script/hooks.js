@@ -0,0 +1,31 @@
+// go-mitmproxy -script examples/script/hooks.js
+// The file is reloaded automatically after saved.
+
+function request(flow) {
+  // add header to every request
+  flow.request.headers['X-Proxy'] = 'go-mitmproxy'
+
+  // mock api, won't send to upstream
+  if (flow.request.url.indexOf('/api/mock') !== -1) {
+    flow.response = {
+      status: 200,
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ mocked: true }),
+    }
+  }
+}
+
+function response(flow) {
+  var contentType = flow.response.headers['Content-Type'] || ''
+  if (contentType.indexOf('text/html') !== -1) {
+    flow.response.body = flow.response.body.replace('<title>', '<title>[proxied] ')
+  }
+}
+
+function request_error(flow, err) {
+  console.log('request error', flow.request.url, err)
+}
+
+function websocket_message(flow, message) {
+  console.log('websocket', message.fromClient ? '->' : '<-', message.content)
+}
@@ -56,6 +56,12 @@ func (ca *TrustedCA) GetCert(commonName string) (*tls.Certificate, error) {
 	return val.(*tls.Certificate), nil
 }
 
+// GetCertForConn is optional, see cert.ConnCA
+// the certificate could be chosen by the client hello, such as chi.SupportedProtos, and the upstream certificate
+func (ca *TrustedCA) GetCertForConn(chi *tls.ClientHelloInfo, upstream *x509.Certificate) (*tls.Certificate, error) {
+	return ca.GetCert(chi.ServerName)
+}
+
 func (ca *TrustedCA) loadCert(commonName string) (*tls.Certificate, error) {
 	switch commonName {
 	case "your-domain.xx.com":
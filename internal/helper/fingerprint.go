@@ -0,0 +1,284 @@
+package helper
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// tls extension types used by the fingerprints
+const (
+	extServerName          uint16 = 0
+	extSupportedGroups     uint16 = 10
+	extECPointFormats      uint16 = 11
+	extSignatureAlgorithms uint16 = 13
+	extALPN                uint16 = 16
+	extSupportedVersions   uint16 = 43
+)
+
+var errInvalidHello = errors.New("invalid tls hello record")
+
+// https://datatracker.ietf.org/doc/html/rfc8701
+func isGrease(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// helloReader read the fields of hello message
+type helloReader struct {
+	data []byte
+	err  bool
+}
+
+func (r *helloReader) bytes(n int) []byte {
+	if r.err || len(r.data) < n {
+		r.err = true
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *helloReader) uint8() int {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return int(b[0])
+}
+
+func (r *helloReader) uint16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (r *helloReader) uint16s(n int) []uint16 {
+	b := r.bytes(n)
+	vs := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		vs = append(vs, binary.BigEndian.Uint16(b[i:]))
+	}
+	return vs
+}
+
+type helloExtension struct {
+	typ  uint16
+	data []byte
+}
+
+// helloMessage the body of the handshake message in the first tls record
+func helloMessage(record []byte, handshakeType byte) (*helloReader, error) {
+	if len(record) < 9 || record[0] != 0x16 || record[5] != handshakeType {
+		return nil, errInvalidHello
+	}
+	n := int(record[6])<<16 | int(record[7])<<8 | int(record[8])
+	if len(record) < 9+n {
+		return nil, errInvalidHello
+	}
+	return &helloReader{data: record[9 : 9+n]}, nil
+}
+
+func (r *helloReader) extensions() []helloExtension {
+	if len(r.data) == 0 {
+		return nil
+	}
+	er := &helloReader{data: r.bytes(int(r.uint16()))}
+	exts := make([]helloExtension, 0)
+	for len(er.data) > 0 && !er.err {
+		typ := er.uint16()
+		data := er.bytes(int(er.uint16()))
+		exts = append(exts, helloExtension{typ, data})
+	}
+	r.err = r.err || er.err
+	return exts
+}
+
+func joinUint16s(vs []uint16, format func(uint16) string, sep string) string {
+	ss := make([]string, 0, len(vs))
+	for _, v := range vs {
+		if !isGrease(v) {
+			ss = append(ss, format(v))
+		}
+	}
+	return strings.Join(ss, sep)
+}
+
+func decimal(v uint16) string { return strconv.Itoa(int(v)) }
+func hex4(v uint16) string    { return fmt.Sprintf("%04x", v) }
+
+func withoutGrease(vs []uint16) []uint16 {
+	out := make([]uint16, 0, len(vs))
+	for _, v := range vs {
+		if !isGrease(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// ClientHelloFingerprint JA3 (md5 hex) and JA4 of the first tls record sent by client
+func ClientHelloFingerprint(record []byte) (ja3 string, ja4 string, err error) {
+	r, err := helloMessage(record, 1)
+	if err != nil {
+		return "", "", err
+	}
+	version := r.uint16()
+	r.bytes(32) // random
+	r.bytes(r.uint8())
+	ciphers := r.uint16s(int(r.uint16()))
+	r.bytes(r.uint8()) // compression methods
+	exts := r.extensions()
+	if r.err {
+		return "", "", errInvalidHello
+	}
+
+	var extTypes, curves, sigAlgs, versions []uint16
+	var points []byte
+	var sni bool
+	var alpn string
+	for _, ext := range exts {
+		extTypes = append(extTypes, ext.typ)
+		er := &helloReader{data: ext.data}
+		switch ext.typ {
+		case extServerName:
+			sni = true
+		case extSupportedGroups:
+			curves = er.uint16s(int(er.uint16()))
+		case extECPointFormats:
+			points = er.bytes(er.uint8())
+		case extSignatureAlgorithms:
+			sigAlgs = er.uint16s(int(er.uint16()))
+		case extSupportedVersions:
+			versions = er.uint16s(er.uint8())
+		case extALPN:
+			er.uint16()
+			alpn = string(er.bytes(er.uint8()))
+		}
+	}
+
+	// JA3: SSLVersion,Ciphers,Extensions,EllipticCurves,EllipticCurvePointFormats
+	pointStrs := make([]string, len(points))
+	for i, p := range points {
+		pointStrs[i] = strconv.Itoa(int(p))
+	}
+	ja3Str := strings.Join([]string{
+		decimal(version),
+		joinUint16s(ciphers, decimal, "-"),
+		joinUint16s(extTypes, decimal, "-"),
+		joinUint16s(curves, decimal, "-"),
+		strings.Join(pointStrs, "-"),
+	}, ",")
+	sum := md5.Sum([]byte(ja3Str))
+	ja3 = hex.EncodeToString(sum[:])
+
+	// JA4: https://github.com/FoxIO-LLC/ja4/blob/main/technical_details/JA4.md
+	for _, v := range withoutGrease(versions) {
+		if v > version {
+			version = v
+		}
+	}
+	sniFlag := "i"
+	if sni {
+		sniFlag = "d"
+	}
+	ciphers = withoutGrease(ciphers)
+	extTypes = withoutGrease(extTypes)
+	ja4a := fmt.Sprintf("t%v%v%02d%02d%v", ja4Version(version), sniFlag, min(len(ciphers), 99), min(len(extTypes), 99), ja4ALPN(alpn))
+
+	sortedCiphers := append([]uint16{}, ciphers...)
+	sort.Slice(sortedCiphers, func(i, j int) bool { return sortedCiphers[i] < sortedCiphers[j] })
+	ja4b := ja4Hash(joinUint16s(sortedCiphers, hex4, ","))
+
+	sortedExts := make([]uint16, 0, len(extTypes))
+	for _, t := range extTypes {
+		if t != extServerName && t != extALPN {
+			sortedExts = append(sortedExts, t)
+		}
+	}
+	sort.Slice(sortedExts, func(i, j int) bool { return sortedExts[i] < sortedExts[j] })
+	ja4cStr := joinUint16s(sortedExts, hex4, ",")
+	if len(sigAlgs) > 0 {
+		ja4cStr += "_" + joinUint16s(sigAlgs, hex4, ",")
+	}
+	ja4c := ja4Hash(ja4cStr)
+	if len(sortedExts) == 0 {
+		ja4c = "000000000000"
+	}
+
+	return ja3, ja4a + "_" + ja4b + "_" + ja4c, nil
+}
+
+func ja4Version(v uint16) string {
+	switch v {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	}
+	return "00"
+}
+
+func ja4ALPN(alpn string) string {
+	if alpn == "" {
+		return "00"
+	}
+	isAlnum := func(c byte) bool {
+		return (c >= '0' && c <= '9') || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+	}
+	first, last := alpn[0], alpn[len(alpn)-1]
+	if isAlnum(first) && isAlnum(last) {
+		return string([]byte{first, last})
+	}
+	h := hex.EncodeToString([]byte(alpn))
+	return string([]byte{h[0], h[len(h)-1]})
+}
+
+// first 12 characters of sha256 hex, 000000000000 if empty
+func ja4Hash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// ServerHelloFingerprint JA3S (md5 hex) of the first tls record sent by server
+func ServerHelloFingerprint(record []byte) (string, error) {
+	r, err := helloMessage(record, 2)
+	if err != nil {
+		return "", err
+	}
+	version := r.uint16()
+	r.bytes(32) // random
+	r.bytes(r.uint8())
+	cipher := r.uint16()
+	r.uint8() // compression method
+	exts := r.extensions()
+	if r.err {
+		return "", errInvalidHello
+	}
+
+	extTypes := make([]uint16, len(exts))
+	for i, ext := range exts {
+		extTypes[i] = ext.typ
+	}
+	// JA3S: SSLVersion,Cipher,Extensions
+	ja3sStr := decimal(version) + "," + decimal(cipher) + "," + joinUint16s(extTypes, decimal, "-")
+	sum := md5.Sum([]byte(ja3sStr))
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,85 @@
+package helper
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+func u16(v int) []byte {
+	return binary.BigEndian.AppendUint16(nil, uint16(v))
+}
+
+func concat(bs ...[]byte) []byte {
+	var out []byte
+	for _, b := range bs {
+		out = append(out, b...)
+	}
+	return out
+}
+
+func extension(typ int, data []byte) []byte {
+	return concat(u16(typ), u16(len(data)), data)
+}
+
+func record(handshakeType byte, body []byte) []byte {
+	msg := concat([]byte{handshakeType, 0, byte(len(body) >> 8), byte(len(body))}, body)
+	return concat([]byte{0x16, 0x03, 0x01}, u16(len(msg)), msg)
+}
+
+func TestClientHelloFingerprint(t *testing.T) {
+	exts := concat(
+		extension(0x0a0a, nil), // grease
+		extension(0, concat(u16(8), []byte{0}, u16(5), []byte("a.com"))),
+		extension(10, concat(u16(4), u16(0x001d), u16(0x0017))),
+		extension(11, []byte{1, 0}),
+		extension(13, concat(u16(4), u16(0x0403), u16(0x0804))),
+		extension(16, concat(u16(3), []byte{2}, []byte("h2"))),
+		extension(43, concat([]byte{4}, u16(0x0304), u16(0x0303))),
+	)
+	body := concat(
+		u16(0x0303),
+		make([]byte, 32),
+		[]byte{0},
+		concat(u16(6), u16(0x0a0a), u16(0x1301), u16(0xc02f)),
+		[]byte{1, 0},
+		u16(len(exts)), exts,
+	)
+
+	ja3, ja4, err := ClientHelloFingerprint(record(1, body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := md5.Sum([]byte("771,4865-49199,0-10-11-13-16-43,29-23,0"))
+	if ja3 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected ja3 %v", ja3)
+	}
+	hash := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])[:12]
+	}
+	expectJa4 := "t13d0206h2_" + hash("1301,c02f") + "_" + hash("000a,000b,000d,002b_0403,0804")
+	if ja4 != expectJa4 {
+		t.Fatalf("expected ja4 %v, but got %v", expectJa4, ja4)
+	}
+
+	if _, _, err := ClientHelloFingerprint(record(1, body)[:20]); err == nil {
+		t.Fatal("expected error of truncated record")
+	}
+}
+
+func TestServerHelloFingerprint(t *testing.T) {
+	exts := concat(extension(43, u16(0x0304)), extension(51, make([]byte, 4)))
+	body := concat(u16(0x0303), make([]byte, 32), []byte{0}, u16(0x1301), []byte{0}, u16(len(exts)), exts)
+
+	ja3s, err := ServerHelloFingerprint(record(2, body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := md5.Sum([]byte("771,4865,43-51"))
+	if ja3s != hex.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected ja3s %v", ja3s)
+	}
+}
@@ -9,7 +9,10 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
+
+	"gopkg.in/yaml.v3"
 )
 
 // 尝试将 Reader 读取至 buffer 中
@@ -39,6 +42,16 @@ func NewStructFromFile(filename string, v interface{}) error {
 	if err != nil {
 		return err
 	}
+	// yaml is converted to json, so that the field names are matched in the same way
+	if ext := strings.ToLower(filepath.Ext(filename)); ext == ".yaml" || ext == ".yml" {
+		var obj interface{}
+		if err := yaml.Unmarshal(data, &obj); err != nil {
+			return err
+		}
+		if data, err = json.Marshal(obj); err != nil {
+			return err
+		}
+	}
 	if err := json.Unmarshal(data, v); err != nil {
 		return err
 	}
@@ -71,6 +84,18 @@ func IsTls(buf []byte) bool {
 	}
 }
 
+var httpMethods = []string{"GET", "POST", "PUT", "DELETE", "HEAD", "OPTIONS", "PATCH", "CONNECT", "TRACE"}
+
+// IsHttp detect buf is the beginning of a http/1.x request
+func IsHttp(buf []byte) bool {
+	for _, method := range httpMethods {
+		if bytes.HasPrefix(buf, []byte(method+" ")) {
+			return true
+		}
+	}
+	return false
+}
+
 func IsWebSocket(buf []byte) bool {
 	// 使用 http.ReadRequest 解析 WebSocket 握手请求
 	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf)))
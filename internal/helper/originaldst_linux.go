@@ -0,0 +1,75 @@
+//go:build linux
+
+package helper
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// from linux/netfilter_ipv4.h and linux/netfilter_ipv6/ip6_tables.h
+const (
+	soOriginalDst     = 80
+	ip6tSoOriginalDst = 80
+)
+
+// GetOriginalDst returns the destination address of a connection redirected
+// by iptables/nftables REDIRECT or DNAT, read from SO_ORIGINAL_DST.
+func GetOriginalDst(conn net.Conn) (string, error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return "", errors.New("original dst: not a tcp connection")
+	}
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+
+	isIPv6 := false
+	if addr, ok := tcpConn.LocalAddr().(*net.TCPAddr); ok && addr.IP.To4() == nil {
+		isIPv6 = true
+	}
+
+	var addr string
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		if isIPv6 {
+			info, err := unix.GetsockoptIPv6MTUInfo(int(fd), unix.IPPROTO_IPV6, ip6tSoOriginalDst)
+			if err != nil {
+				sockErr = err
+				return
+			}
+			raw := info.Addr
+			port := ntohs(raw.Port)
+			addr = net.JoinHostPort(net.IP(raw.Addr[:]).String(), strconv.Itoa(int(port)))
+			return
+		}
+
+		// struct sockaddr_in has the same size as struct ipv6_mreq
+		mreq, err := unix.GetsockoptIPv6Mreq(int(fd), unix.IPPROTO_IP, soOriginalDst)
+		if err != nil {
+			sockErr = err
+			return
+		}
+		raw := (*syscall.RawSockaddrInet4)(unsafe.Pointer(&mreq.Multiaddr[0]))
+		port := ntohs(raw.Port)
+		addr = net.JoinHostPort(net.IP(raw.Addr[:]).String(), strconv.Itoa(int(port)))
+	})
+	if err != nil {
+		return "", err
+	}
+	if sockErr != nil {
+		return "", sockErr
+	}
+	return addr, nil
+}
+
+func ntohs(port uint16) uint16 {
+	b := (*[2]byte)(unsafe.Pointer(&port))
+	return uint16(b[0])<<8 | uint16(b[1])
+}
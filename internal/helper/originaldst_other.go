@@ -0,0 +1,13 @@
+//go:build !linux
+
+package helper
+
+import (
+	"errors"
+	"net"
+)
+
+// GetOriginalDst is only supported on linux.
+func GetOriginalDst(conn net.Conn) (string, error) {
+	return "", errors.New("original dst: only supported on linux")
+}
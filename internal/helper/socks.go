@@ -0,0 +1,275 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// socks server side handshake
+// socks4/4a: https://www.openssh.com/txt/socks4.protocol https://www.openssh.com/txt/socks4a.protocol
+// socks5: https://www.rfc-editor.org/rfc/rfc1928 https://www.rfc-editor.org/rfc/rfc1929
+
+const (
+	socks4Version = 0x04
+	socks5Version = 0x05
+
+	socksCmdConnect = 0x01
+
+	socks5AuthNone         = 0x00
+	socks5AuthPassword     = 0x02
+	socks5AuthNoAcceptable = 0xff
+
+	socks5AtypIPv4   = 0x01
+	socks5AtypDomain = 0x03
+	socks5AtypIPv6   = 0x04
+
+	socks5RepSucceeded          = 0x00
+	socks5RepGeneralFailure     = 0x01
+	socks5RepCmdNotSupported    = 0x07
+	socks5RepAtypNotSupported   = 0x08
+	socks4RepGranted            = 0x5a
+	socks4RepRejected           = 0x5b
+	socks5PasswordAuthVersion   = 0x01
+	socks5PasswordAuthSucceeded = 0x00
+	socks5PasswordAuthFailed    = 0x01
+
+	socks4MaxStringLen = 255
+)
+
+var errSocksAuthFailed = errors.New("socks authentication failed")
+
+// IsSocks detect the first byte of connection is socks4 or socks5 version
+func IsSocks(buf []byte) bool {
+	return len(buf) > 0 && (buf[0] == socks4Version || buf[0] == socks5Version)
+}
+
+type SocksRequest struct {
+	Version  byte
+	Addr     string // target address, host:port
+	User     string
+	Password string
+}
+
+// ReadSocksRequest read the socks handshake from client until the CONNECT command.
+// If auth is not nil, socks5 username/password authentication is required and socks4 is rejected.
+// The caller should reply the result of connecting by WriteSocksReply.
+// r should be the only reader of the connection, so the data after handshake would not be lost.
+func ReadSocksRequest(r *bufio.Reader, w io.Writer, auth func(user, password string) bool) (*SocksRequest, error) {
+	version, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch version {
+	case socks4Version:
+		return readSocks4Request(r, w, auth)
+	case socks5Version:
+		return readSocks5Request(r, w, auth)
+	default:
+		return nil, fmt.Errorf("socks: unknown version %v", version)
+	}
+}
+
+func readSocks4Request(r *bufio.Reader, w io.Writer, auth func(user, password string) bool) (*SocksRequest, error) {
+	// CMD(1) DSTPORT(2) DSTIP(4) USERID NULL [HOSTNAME NULL]
+	head := make([]byte, 7)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	user, err := readSocks4String(r)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &SocksRequest{Version: socks4Version, User: user}
+	if head[0] != socksCmdConnect {
+		WriteSocksReply(w, socks4Version, false)
+		return nil, fmt.Errorf("socks4: command %v not supported", head[0])
+	}
+	if auth != nil {
+		WriteSocksReply(w, socks4Version, false)
+		return nil, errSocksAuthFailed
+	}
+
+	port := binary.BigEndian.Uint16(head[1:3])
+	ip := net.IP(head[3:7])
+	host := ip.String()
+	// socks4a: 0.0.0.x with x nonzero means hostname follows
+	if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		host, err = readSocks4String(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	req.Addr = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	return req, nil
+}
+
+// read the NULL terminated userid or hostname of socks4, at most socks4MaxStringLen bytes like socks5
+func readSocks4String(r *bufio.Reader) (string, error) {
+	buf := make([]byte, 0, 64)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == 0 {
+			return string(buf), nil
+		}
+		if len(buf) == socks4MaxStringLen {
+			return "", fmt.Errorf("socks4: userid or hostname longer than %v bytes", socks4MaxStringLen)
+		}
+		buf = append(buf, b)
+	}
+}
+
+func readSocks5Request(r *bufio.Reader, w io.Writer, auth func(user, password string) bool) (*SocksRequest, error) {
+	// method selection: NMETHODS(1) METHODS(NMETHODS)
+	nmethods, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	methods := make([]byte, nmethods)
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return nil, err
+	}
+
+	method := byte(socks5AuthNone)
+	if auth != nil {
+		method = socks5AuthPassword
+	}
+	if bytes.IndexByte(methods, method) == -1 {
+		w.Write([]byte{socks5Version, socks5AuthNoAcceptable})
+		return nil, errors.New("socks5: no acceptable authentication methods")
+	}
+	if _, err := w.Write([]byte{socks5Version, method}); err != nil {
+		return nil, err
+	}
+
+	req := &SocksRequest{Version: socks5Version}
+
+	// username/password: VER(1) ULEN(1) UNAME PLEN(1) PASSWD
+	if method == socks5AuthPassword {
+		user, password, err := readSocks5Password(r)
+		if err != nil {
+			return nil, err
+		}
+		if !auth(user, password) {
+			w.Write([]byte{socks5PasswordAuthVersion, socks5PasswordAuthFailed})
+			return nil, errSocksAuthFailed
+		}
+		if _, err := w.Write([]byte{socks5PasswordAuthVersion, socks5PasswordAuthSucceeded}); err != nil {
+			return nil, err
+		}
+		req.User = user
+		req.Password = password
+	}
+
+	// request: VER(1) CMD(1) RSV(1) ATYP(1) DST.ADDR DST.PORT(2)
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	if head[0] != socks5Version {
+		return nil, fmt.Errorf("socks5: unknown version %v", head[0])
+	}
+	if head[1] != socksCmdConnect {
+		writeSocks5Reply(w, socks5RepCmdNotSupported)
+		return nil, fmt.Errorf("socks5: command %v not supported", head[1])
+	}
+
+	var host string
+	switch head[3] {
+	case socks5AtypIPv4, socks5AtypIPv6:
+		size := net.IPv4len
+		if head[3] == socks5AtypIPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return nil, err
+		}
+		host = net.IP(ip).String()
+	case socks5AtypDomain:
+		l, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		domain := make([]byte, l)
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return nil, err
+		}
+		host = string(domain)
+	default:
+		writeSocks5Reply(w, socks5RepAtypNotSupported)
+		return nil, fmt.Errorf("socks5: address type %v not supported", head[3])
+	}
+
+	portBytes := make([]byte, 2)
+	if _, err := io.ReadFull(r, portBytes); err != nil {
+		return nil, err
+	}
+	port := binary.BigEndian.Uint16(portBytes)
+	req.Addr = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	return req, nil
+}
+
+func readSocks5Password(r *bufio.Reader) (string, string, error) {
+	version, err := r.ReadByte()
+	if err != nil {
+		return "", "", err
+	}
+	if version != socks5PasswordAuthVersion {
+		return "", "", fmt.Errorf("socks5: unknown auth version %v", version)
+	}
+	readString := func() (string, error) {
+		l, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		buf := make([]byte, l)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		return string(buf), nil
+	}
+	user, err := readString()
+	if err != nil {
+		return "", "", err
+	}
+	password, err := readString()
+	if err != nil {
+		return "", "", err
+	}
+	return user, password, nil
+}
+
+// WriteSocksReply reply the result of CONNECT command to client
+func WriteSocksReply(w io.Writer, version byte, success bool) error {
+	if version == socks4Version {
+		rep := byte(socks4RepGranted)
+		if !success {
+			rep = socks4RepRejected
+		}
+		_, err := w.Write([]byte{0x00, rep, 0, 0, 0, 0, 0, 0})
+		return err
+	}
+
+	rep := byte(socks5RepSucceeded)
+	if !success {
+		rep = socks5RepGeneralFailure
+	}
+	return writeSocks5Reply(w, rep)
+}
+
+func writeSocks5Reply(w io.Writer, rep byte) error {
+	// VER REP RSV ATYP BND.ADDR(0.0.0.0) BND.PORT(0)
+	_, err := w.Write([]byte{socks5Version, rep, 0x00, socks5AtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
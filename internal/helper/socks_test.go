@@ -0,0 +1,84 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReadSocksRequest(t *testing.T) {
+	t.Run("socks4", func(t *testing.T) {
+		in := []byte{0x04, 0x01, 0x01, 0xbb, 127, 0, 0, 1, 'u', 0}
+		out := new(bytes.Buffer)
+		req, err := ReadSocksRequest(bufio.NewReader(bytes.NewReader(in)), out, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Addr != "127.0.0.1:443" || req.User != "u" {
+			t.Fatalf("unexpected request %+v", req)
+		}
+	})
+
+	t.Run("socks4a", func(t *testing.T) {
+		in := []byte{0x04, 0x01, 0x00, 0x50, 0, 0, 0, 1, 0}
+		in = append(in, []byte("example.com")...)
+		in = append(in, 0)
+		out := new(bytes.Buffer)
+		req, err := ReadSocksRequest(bufio.NewReader(bytes.NewReader(in)), out, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Addr != "example.com:80" {
+			t.Fatalf("expected %v, but got %v", "example.com:80", req.Addr)
+		}
+	})
+
+	t.Run("socks4 too long", func(t *testing.T) {
+		// userid without NULL
+		in := append([]byte{0x04, 0x01, 0x01, 0xbb, 127, 0, 0, 1}, bytes.Repeat([]byte{'u'}, 1024)...)
+		if _, err := ReadSocksRequest(bufio.NewReader(bytes.NewReader(in)), new(bytes.Buffer), nil); err == nil || err == io.EOF {
+			t.Fatalf("expected too long error, but got %v", err)
+		}
+		// socks4a hostname
+		in = append([]byte{0x04, 0x01, 0x00, 0x50, 0, 0, 0, 1, 0}, bytes.Repeat([]byte{'a'}, 256)...)
+		in = append(in, 0)
+		if _, err := ReadSocksRequest(bufio.NewReader(bytes.NewReader(in)), new(bytes.Buffer), nil); err == nil {
+			t.Fatal("expected too long error")
+		}
+	})
+
+	t.Run("socks5 with password", func(t *testing.T) {
+		in := []byte{0x05, 0x01, 0x02}
+		in = append(in, 0x01, 4, 'u', 's', 'e', 'r', 4, 'p', 'a', 's', 's')
+		in = append(in, 0x05, 0x01, 0x00, 0x03, 11)
+		in = append(in, []byte("example.com")...)
+		in = append(in, 0x01, 0xbb)
+		out := new(bytes.Buffer)
+		auth := func(user, password string) bool {
+			return user == "user" && password == "pass"
+		}
+		req, err := ReadSocksRequest(bufio.NewReader(bytes.NewReader(in)), out, auth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Addr != "example.com:443" || req.User != "user" {
+			t.Fatalf("unexpected request %+v", req)
+		}
+		if !bytes.Equal(out.Bytes(), []byte{0x05, 0x02, 0x01, 0x00}) {
+			t.Fatalf("unexpected reply %v", out.Bytes())
+		}
+	})
+
+	t.Run("socks5 without acceptable method", func(t *testing.T) {
+		in := []byte{0x05, 0x01, 0x00}
+		out := new(bytes.Buffer)
+		_, err := ReadSocksRequest(bufio.NewReader(bytes.NewReader(in)), out, func(user, password string) bool { return true })
+		if err == nil {
+			t.Fatal("should have error")
+		}
+		if !bytes.Equal(out.Bytes(), []byte{0x05, 0xff}) {
+			t.Fatalf("unexpected reply %v", out.Bytes())
+		}
+	})
+}
@@ -1,9 +1,14 @@
 package helper
 
 import (
+	"bytes"
+	"crypto/tls"
+	"errors"
 	"io"
+	"net"
 	"os"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -29,3 +34,36 @@ func GetTlsKeyLogWriter() io.Writer {
 	})
 	return tlsKeyLogWriter
 }
+
+var errClientHelloParsed = errors.New("client hello parsed")
+
+// ParseClientHello parse ClientHello from the tls records sent by client, without handshake
+func ParseClientHello(data []byte) (*tls.ClientHelloInfo, error) {
+	var hello *tls.ClientHelloInfo
+	err := tls.Server(&readOnlyConn{r: bytes.NewReader(data)}, &tls.Config{
+		GetConfigForClient: func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
+			c := *chi
+			c.Conn = nil
+			hello = &c
+			return nil, errClientHelloParsed
+		},
+	}).Handshake()
+	if hello != nil {
+		return hello, nil
+	}
+	return nil, err
+}
+
+// readOnlyConn read from r, discard writes
+type readOnlyConn struct {
+	r io.Reader
+}
+
+func (c *readOnlyConn) Read(p []byte) (int, error)         { return c.r.Read(p) }
+func (c *readOnlyConn) Write(p []byte) (int, error)        { return len(p), nil }
+func (c *readOnlyConn) Close() error                       { return nil }
+func (c *readOnlyConn) LocalAddr() net.Addr                { return nil }
+func (c *readOnlyConn) RemoteAddr() net.Addr               { return nil }
+func (c *readOnlyConn) SetDeadline(t time.Time) error      { return nil }
+func (c *readOnlyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *readOnlyConn) SetWriteDeadline(t time.Time) error { return nil }
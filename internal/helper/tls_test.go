@@ -0,0 +1,34 @@
+package helper
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+func TestParseClientHello(t *testing.T) {
+	client, server := net.Pipe()
+	go func() {
+		tls.Client(client, &tls.Config{ServerName: "example.com", NextProtos: []string{"h2", "http/1.1"}}).Handshake()
+	}()
+	defer client.Close()
+	defer server.Close()
+
+	buf := make([]byte, 16*1024)
+	n, err := server.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chi, err := ParseClientHello(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chi.ServerName != "example.com" || len(chi.SupportedProtos) != 2 || chi.SupportedProtos[0] != "h2" {
+		t.Fatalf("unexpected client hello %+v", chi)
+	}
+
+	if _, err := ParseClientHello(buf[:n/2]); err == nil {
+		t.Fatal("expected error of truncated client hello")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/gorilla/websocket"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -46,6 +47,9 @@ type Addon interface {
 	AccessProxyServer(req *http.Request, res http.ResponseWriter)
 
 	WebSocketStart(*Flow)
+	// A WebSocket message (the last of f.WebScoket.Messages) is about to be forwarded.
+	// Modify its Type and Content, or set Dropped to not forward it.
+	// The hooks of both directions are called one by one per flow.
 	WebSocketMessage(*Flow)
 	WebSocketEnd(*Flow)
 
@@ -123,6 +127,9 @@ func (addon *LogAddon) Response(f *Flow) {
 		contentLen = len(f.Response.Body)
 	}
 	log.Infof("%v %v %v %v %v - %v ms\n", f.ConnContext.ClientConn.Conn.RemoteAddr(), f.Request.Method, f.Request.URL.String(), StatusCode, contentLen, time.Since(f.StartTime).Milliseconds())
+	if f.Timing != nil {
+		log.Debugf("%v %v %v timing: %v\n", f.ConnContext.ClientConn.Conn.RemoteAddr(), f.Request.Method, f.Request.URL.String(), f.Timing)
+	}
 }
 
 func (addon *LogAddon) RequestError(f *Flow, err error) {
@@ -153,8 +160,15 @@ func (addon *LogAddon) WebSocketMessage(f *Flow) {
 		direction = "S->C"
 	}
 	msgType := "TEXT"
-	if lastMsg.Type == 2 {
+	switch lastMsg.Type {
+	case websocket.BinaryMessage:
 		msgType = "BINARY"
+	case websocket.CloseMessage:
+		msgType = "CLOSE"
+	case websocket.PingMessage:
+		msgType = "PING"
+	case websocket.PongMessage:
+		msgType = "PONG"
 	}
 
 	// 只记录消息长度，不记录内容
@@ -168,10 +182,13 @@ func (addon *LogAddon) WebSocketMessage(f *Flow) {
 
 // WebSocketEnd 记录 WebSocket 连接结束
 func (addon *LogAddon) WebSocketEnd(f *Flow) {
-	log.Infof("%v WebSocket END %s - %d messages\n",
+	log.Infof("%v WebSocket END %s - %d messages, closed by %s: %d %s\n",
 		f.ConnContext.ClientConn.Conn.RemoteAddr(),
 		f.Request.URL.String(),
-		len(f.WebScoket.Messages))
+		len(f.WebScoket.Messages),
+		f.WebScoket.ClosedBy,
+		f.WebScoket.CloseCode,
+		f.WebScoket.CloseReason)
 }
 
 // SSEStart 记录 SSE 流开始
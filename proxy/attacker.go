@@ -4,10 +4,14 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/lqqyt2423/go-mitmproxy/cert"
 	"github.com/lqqyt2423/go-mitmproxy/internal/helper"
@@ -42,6 +46,14 @@ type attacker struct {
 	h2Server *http2.Server
 	client   *http.Client
 	listener *attackerListener
+
+	clientsMu sync.Mutex
+	clients   map[separateClientKey]*http.Client // separate clients with client certificate or verification rule
+}
+
+type separateClientKey struct {
+	cert *UpstreamClientCert
+	rule *UpstreamVerifyRule
 }
 
 func newAttacker(proxy *Proxy) (*attacker, error) {
@@ -51,26 +63,13 @@ func newAttacker(proxy *Proxy) (*attacker, error) {
 	}
 
 	a := &attacker{
-		proxy: proxy,
-		ca:    ca,
-		client: &http.Client{
-			Transport: &http.Transport{
-				Proxy:              proxy.realUpstreamProxy(),
-				ForceAttemptHTTP2:  true,
-				DisableCompression: true, // To get the original response from the server, set Transport.DisableCompression to true.
-				TLSClientConfig: &tls.Config{
-					InsecureSkipVerify: proxy.Opts.SslInsecure,
-					KeyLogWriter:       helper.GetTlsKeyLogWriter(),
-				},
-			},
-			CheckRedirect: func(req *http.Request, via []*http.Request) error {
-				// 禁止自动重定向
-				return http.ErrUseLastResponse
-			},
-		},
+		proxy:  proxy,
+		ca:     ca,
+		client: newSeparateClient(proxy, separateClientKey{}),
 		listener: &attackerListener{
 			connChan: make(chan net.Conn),
 		},
+		clients: make(map[separateClientKey]*http.Client),
 	}
 
 	a.server = &http.Server{
@@ -83,16 +82,69 @@ func newAttacker(proxy *Proxy) (*attacker, error) {
 	a.h2Server = &http2.Server{
 		MaxConcurrentStreams: 100, // todo: wait for remote server setting
 	}
+	// configure http2 of a.server before a.server.Serve and a.h2Server.ServeConn, which both read it
+	if err := http2.ConfigureServer(a.server, a.h2Server); err != nil {
+		return nil, err
+	}
 
 	return a, nil
 }
 
+// client of the separate requests, not bound to the client connection
+func newSeparateClient(proxy *Proxy, key separateClientKey) *http.Client {
+	tlsConfig := &tls.Config{
+		KeyLogWriter: helper.GetTlsKeyLogWriter(),
+	}
+	if key.cert != nil {
+		tlsConfig.Certificates = []tls.Certificate{*key.cert.cert}
+	}
+	proxy.setUpstreamVerify(tlsConfig, key.rule)
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:              proxy.realUpstreamProxy(),
+			ForceAttemptHTTP2:  true,
+			DisableCompression: true, // To get the original response from the server, set Transport.DisableCompression to true.
+			TLSClientConfig:    tlsConfig,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// 禁止自动重定向
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// use the client with client certificate or verification rule if the host matched
+func (a *attacker) separateClient(u *url.URL) *http.Client {
+	address := upstreamMatchAddress(u.Hostname(), helper.CanonicalAddr(u))
+	key := separateClientKey{
+		cert: a.proxy.upstreamClientCert(address),
+		rule: a.proxy.upstreamVerifyRule(address),
+	}
+	if key == (separateClientKey{}) {
+		return a.client
+	}
+
+	a.clientsMu.Lock()
+	defer a.clientsMu.Unlock()
+	c, ok := a.clients[key]
+	if !ok {
+		c = newSeparateClient(a.proxy, key)
+		a.clients[key] = c
+	}
+	return c
+}
+
 func newCa(opts *Options) (cert.CA, error) {
 	newCaFunc := opts.NewCaFunc
 	if newCaFunc != nil {
 		return newCaFunc()
 	}
-	return cert.NewSelfSignCA(opts.CaRootPath)
+	caOpts := cert.SelfSignCAOptions{}
+	if opts.CaOptions != nil {
+		caOpts = *opts.CaOptions
+	}
+	caOpts.Wildcard = caOpts.Wildcard || opts.CertWildcard
+	return cert.NewSelfSignCAWithOptions(opts.CaRootPath, &caOpts)
 }
 
 func (a *attacker) start() error {
@@ -100,7 +152,9 @@ func (a *attacker) start() error {
 }
 
 func (a *attacker) serveConn(clientTlsConn *tls.Conn, connCtx *ConnContext) {
-	connCtx.ClientConn.NegotiatedProtocol = clientTlsConn.ConnectionState().NegotiatedProtocol
+	clientTlsState := clientTlsConn.ConnectionState()
+	connCtx.ClientConn.NegotiatedProtocol = clientTlsState.NegotiatedProtocol
+	connCtx.ClientConn.PeerCertificates = clientTlsState.PeerCertificates
 
 	if connCtx.ClientConn.NegotiatedProtocol == "h2" && connCtx.ServerConn != nil {
 		connCtx.ServerConn.client = &http.Client{
@@ -145,6 +199,9 @@ func (a *attacker) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	if req.URL.Host == "" {
 		req.URL.Host = req.Host
 	}
+	if a.proxy.isReverse() {
+		a.proxy.rewriteReverseURL(req)
+	}
 
 	if strings.EqualFold(req.Header.Get("Connection"), "Upgrade") && strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
 		f := newFlow()
@@ -155,24 +212,13 @@ func (a *attacker) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 		for _, addon := range a.proxy.Addons {
 			addon.Requestheaders(f)
 			if f.Response != nil {
-				if f.Response.Header != nil {
-					for key, vals := range f.Response.Header {
-						for _, v := range vals {
-							res.Header().Add(key, v)
-						}
-					}
-				}
-				res.WriteHeader(f.Response.StatusCode)
-				if len(f.Response.Body) > 0 {
-					_, _ = res.Write(f.Response.Body)
-				}
+				writeResponse(res, f.Response)
 				f.finish()
 				return
 			}
 		}
-		f.finish()
 
-		if err := a.proxy.webSocketHandler.handleWSS(res, req); err != nil {
+		if err := a.proxy.webSocketHandler.handleWSS(res, req, f); err != nil {
 			log.Errorf("handleWSS error: %v", err)
 		}
 		return
@@ -185,7 +231,8 @@ func (a *attacker) initHttpDialFn(req *http.Request) {
 	connCtx := req.Context().Value(connContextKey).(*ConnContext)
 	connCtx.dialFn = func(ctx context.Context) error {
 		addr := helper.CanonicalAddr(req.URL)
-		c, err := a.proxy.getUpstreamConn(ctx, req)
+		serverConn := newServerConn()
+		c, err := a.proxy.getUpstreamConn(withDialTiming(ctx, serverConn), req)
 		if err != nil {
 			return err
 		}
@@ -196,7 +243,6 @@ func (a *attacker) initHttpDialFn(req *http.Request) {
 			connCtx: connCtx,
 		}
 
-		serverConn := newServerConn()
 		serverConn.Conn = cw
 		serverConn.Address = addr
 		serverConn.client = &http.Client{
@@ -229,14 +275,37 @@ func (a *attacker) serverTlsHandshake(ctx context.Context, connCtx *ConnContext)
 	serverConn := connCtx.ServerConn
 
 	serverTlsConfig := &tls.Config{
-		InsecureSkipVerify: proxy.Opts.SslInsecure,
-		KeyLogWriter:       helper.GetTlsKeyLogWriter(),
-		ServerName:         clientHello.ServerName,
-		NextProtos:         clientHello.SupportedProtos,
-		// CurvePreferences:   clientHello.SupportedCurves, // todo: 如果打开会出错
-		CipherSuites: clientHello.CipherSuites,
-	}
-	if len(clientHello.SupportedVersions) > 0 {
+		KeyLogWriter: helper.GetTlsKeyLogWriter(),
+	}
+	// plain http client in reverse mode has no clientHello
+	if clientHello != nil {
+		serverTlsConfig.ServerName = clientHello.ServerName
+		serverTlsConfig.NextProtos = clientHello.SupportedProtos
+		// serverTlsConfig.CurvePreferences = clientHello.SupportedCurves // todo: 如果打开会出错
+		serverTlsConfig.CipherSuites = clientHello.CipherSuites
+	}
+	if serverTlsConfig.ServerName == "" || proxy.isReverse() {
+		// client without SNI, such as requesting an ip address directly, or the backend of reverse mode
+		serverTlsConfig.ServerName, _, _ = net.SplitHostPort(serverConn.Address)
+	}
+	// the address may be ip in transparent and socks mode, match the rules by SNI
+	matchAddress := upstreamMatchAddress(serverTlsConfig.ServerName, serverConn.Address)
+	proxy.setUpstreamVerify(serverTlsConfig, proxy.upstreamVerifyRule(matchAddress))
+	if proxy.forwardCertFn != nil {
+		serverTlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if c, err := proxy.forwardCertFn(connCtx); c != nil || err != nil {
+				return c, err
+			}
+			if c := proxy.upstreamClientCert(matchAddress); c != nil {
+				return c.cert, nil
+			}
+			// no certificate
+			return &tls.Certificate{}, nil
+		}
+	} else if c := proxy.upstreamClientCert(matchAddress); c != nil {
+		serverTlsConfig.Certificates = []tls.Certificate{*c.cert}
+	}
+	if clientHello != nil && len(clientHello.SupportedVersions) > 0 {
 		minVersion := clientHello.SupportedVersions[0]
 		maxVersion := clientHello.SupportedVersions[0]
 		for _, version := range clientHello.SupportedVersions {
@@ -250,25 +319,47 @@ func (a *attacker) serverTlsHandshake(ctx context.Context, connCtx *ConnContext)
 		serverTlsConfig.MinVersion = minVersion
 		serverTlsConfig.MaxVersion = maxVersion
 	}
-	serverTlsConn := tls.Client(serverConn.Conn, serverTlsConfig)
-	serverConn.tlsConn = serverTlsConn
-	if err := serverTlsConn.HandshakeContext(ctx); err != nil {
-		return err
+	hc := &helloConn{Conn: serverConn.Conn}
+	if spec := proxy.upstreamHelloSpec(connCtx.ClientConn); spec != nil {
+		serverTlsConn, serverTlsState, err := utlsHandshake(ctx, hc, serverTlsConfig, spec)
+		if err != nil {
+			return err
+		}
+		serverConn.tlsConn = serverTlsConn
+		serverConn.tlsState = serverTlsState
+	} else {
+		serverTlsConn := tls.Client(hc, serverTlsConfig)
+		if err := serverTlsConn.HandshakeContext(ctx); err != nil {
+			return err
+		}
+		serverConn.tlsConn = serverTlsConn
+		serverTlsState := serverTlsConn.ConnectionState()
+		serverConn.tlsState = &serverTlsState
 	}
-	serverTlsState := serverTlsConn.ConnectionState()
-	serverConn.tlsState = &serverTlsState
+	serverConn.setFingerprint(hc)
+	serverConn.tlsHandshakeDoneTime = time.Now()
 	for _, addon := range proxy.Addons {
 		addon.TlsEstablishedServer(connCtx)
 	}
 
-	serverConn.client = &http.Client{
-		Transport: &http.Transport{
-			DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-				return serverTlsConn, nil
-			},
-			ForceAttemptHTTP2:  true,
-			DisableCompression: true, // To get the original response from the server, set Transport.DisableCompression to true.
+	var transport http.RoundTripper = &http.Transport{
+		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return serverConn.tlsConn, nil
 		},
+		ForceAttemptHTTP2:  true,
+		DisableCompression: true, // To get the original response from the server, set Transport.DisableCompression to true.
+	}
+	// http.Transport only speaks http2 over *tls.Conn
+	if _, ok := serverConn.tlsConn.(*tls.Conn); !ok && serverConn.tlsState.NegotiatedProtocol == "h2" {
+		transport = &http2.Transport{
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return serverConn.tlsConn, nil
+			},
+			DisableCompression: true,
+		}
+	}
+	serverConn.client = &http.Client{
+		Transport: transport,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			// 禁止自动重定向
 			return http.ErrUseLastResponse
@@ -297,12 +388,12 @@ func (a *attacker) httpsDial(ctx context.Context, req *http.Request) (net.Conn,
 	proxy := a.proxy
 	connCtx := req.Context().Value(connContextKey).(*ConnContext)
 
-	plainConn, err := proxy.getUpstreamConn(ctx, req)
+	serverConn := newServerConn()
+	plainConn, err := proxy.getUpstreamConn(withDialTiming(ctx, serverConn), req)
 	if err != nil {
 		return nil, err
 	}
 
-	serverConn := newServerConn()
 	serverConn.Address = req.Host
 	serverConn.Conn = &wrapServerConn{
 		Conn:    plainConn,
@@ -317,7 +408,8 @@ func (a *attacker) httpsDial(ctx context.Context, req *http.Request) (net.Conn,
 	return serverConn.Conn, nil
 }
 
-func (a *attacker) httpsTlsDial(ctx context.Context, cconn net.Conn, conn net.Conn) {
+// httpsTlsDial return the error of upstream tls handshake, other errors are logged only
+func (a *attacker) httpsTlsDial(ctx context.Context, cconn net.Conn, conn net.Conn) error {
 	connCtx := cconn.(*wrapClientConn).connCtx
 	log := log.WithFields(log.Fields{
 		"in":   "Proxy.attacker.httpsTlsDial",
@@ -331,7 +423,8 @@ func (a *attacker) httpsTlsDial(ctx context.Context, cconn net.Conn, conn net.Co
 	errChan2 := make(chan error, 1)
 	clientHandshakeDoneChan := make(chan struct{})
 
-	clientTlsConn := tls.Server(cconn, &tls.Config{
+	hc := &helloConn{Conn: cconn}
+	clientTlsConn := tls.Server(hc, &tls.Config{
 		SessionTicketsDisabled: true, // 设置此值为 true ，确保每次都会调用下面的 GetConfigForClient 方法
 		GetConfigForClient: func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
 			clientHelloChan <- chi
@@ -347,7 +440,7 @@ func (a *attacker) httpsTlsDial(ctx context.Context, cconn net.Conn, conn net.Co
 				}
 			}
 
-			c, err := a.ca.GetCert(chi.ServerName)
+			c, err := a.getCert(connCtx, chi)
 			if err != nil {
 				return nil, err
 			}
@@ -355,6 +448,7 @@ func (a *attacker) httpsTlsDial(ctx context.Context, cconn net.Conn, conn net.Co
 				SessionTicketsDisabled: true,
 				Certificates:           []tls.Certificate{*c},
 				NextProtos:             nextProtos,
+				ClientAuth:             a.proxy.Opts.ClientAuth,
 			}, nil
 
 		},
@@ -373,17 +467,18 @@ func (a *attacker) httpsTlsDial(ctx context.Context, cconn net.Conn, conn net.Co
 		cconn.Close()
 		conn.Close()
 		log.Error(err)
-		return
+		return nil
 	case clientHello = <-clientHelloChan:
 	}
 	connCtx.ClientConn.clientHello = clientHello
+	connCtx.ClientConn.setFingerprint(hc)
 
 	if err := a.serverTlsHandshake(ctx, connCtx); err != nil {
 		cconn.Close()
 		conn.Close()
 		errChan2 <- err
 		log.Error(err)
-		return
+		return err
 	}
 	serverTlsStateChan <- connCtx.ServerConn.tlsState
 
@@ -393,12 +488,15 @@ func (a *attacker) httpsTlsDial(ctx context.Context, cconn net.Conn, conn net.Co
 		cconn.Close()
 		conn.Close()
 		log.Error(err)
-		return
+		a.proxy.tlsClientFailed(connCtx, err)
+		return nil
 	case <-clientHandshakeDoneChan:
 	}
+	a.proxy.tlsClientSucceeded(connCtx)
 
 	// will go to attacker.ServeHTTP
 	a.serveConn(clientTlsConn, connCtx)
+	return nil
 }
 
 func (a *attacker) httpsLazyAttack(ctx context.Context, cconn net.Conn, req *http.Request) {
@@ -408,11 +506,13 @@ func (a *attacker) httpsLazyAttack(ctx context.Context, cconn net.Conn, req *htt
 		"host": connCtx.ClientConn.Conn.RemoteAddr().String(),
 	})
 
-	clientTlsConn := tls.Server(cconn, &tls.Config{
+	hc := &helloConn{Conn: cconn}
+	clientTlsConn := tls.Server(hc, &tls.Config{
 		SessionTicketsDisabled: true, // 设置此值为 true ，确保每次都会调用下面的 GetConfigForClient 方法
 		GetConfigForClient: func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
 			connCtx.ClientConn.clientHello = chi
-			c, err := a.ca.GetCert(chi.ServerName)
+			connCtx.ClientConn.setFingerprint(hc)
+			c, err := a.getCert(connCtx, chi)
 			if err != nil {
 				return nil, err
 			}
@@ -420,20 +520,64 @@ func (a *attacker) httpsLazyAttack(ctx context.Context, cconn net.Conn, req *htt
 				SessionTicketsDisabled: true,
 				Certificates:           []tls.Certificate{*c},
 				NextProtos:             []string{"http/1.1"}, // only support http/1.1
+				ClientAuth:             a.proxy.Opts.ClientAuth,
 			}, nil
 		},
 	})
 	if err := clientTlsConn.HandshakeContext(ctx); err != nil {
 		cconn.Close()
 		log.Error(err)
+		a.proxy.tlsClientFailed(connCtx, err)
 		return
 	}
+	a.proxy.tlsClientSucceeded(connCtx)
 
 	// will go to attacker.ServeHTTP
-	a.initHttpsDialFn(req)
+	if a.proxy.isReverse() {
+		a.initReverseDialFn(connCtx)
+	} else {
+		a.initHttpsDialFn(req)
+	}
 	a.serveConn(clientTlsConn, connCtx)
 }
 
+// fake certificate for client, mirror the upstream certificate if connected to upstream
+func (a *attacker) getCert(connCtx *ConnContext, chi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return getCertForConn(a.ca, chi, certCommonName(connCtx, chi), connCtx.ServerConn.upstreamCert())
+}
+
+// use cert.ConnCA if implemented, otherwise cert.CA.GetCert
+func getCertForConn(ca cert.CA, chi *tls.ClientHelloInfo, serverName string, upstream *x509.Certificate) (*tls.Certificate, error) {
+	connCA, ok := ca.(cert.ConnCA)
+	if !ok {
+		return ca.GetCert(serverName)
+	}
+	if chi.ServerName != serverName {
+		clientHello := *chi
+		clientHello.ServerName = serverName
+		chi = &clientHello
+	}
+	return connCA.GetCertForConn(chi, upstream)
+}
+
+// commonName of the fake certificate, fallback to the backend or the original destination when client send no SNI
+func certCommonName(connCtx *ConnContext, chi *tls.ClientHelloInfo) string {
+	if chi.ServerName != "" {
+		return chi.ServerName
+	}
+	if connCtx.proxy.isReverse() {
+		return connCtx.proxy.reverseUrl.Hostname()
+	}
+	if connCtx.ClientConn.originalDst == "" {
+		return chi.ServerName
+	}
+	host, _, err := net.SplitHostPort(connCtx.ClientConn.originalDst)
+	if err != nil {
+		return chi.ServerName
+	}
+	return host
+}
+
 func (a *attacker) attack(res http.ResponseWriter, req *http.Request) {
 	proxy := a.proxy
 
@@ -511,6 +655,13 @@ func (a *attacker) attack(res http.ResponseWriter, req *http.Request) {
 	f := newFlow()
 	f.Request = newRequest(req)
 	f.ConnContext = req.Context().Value(connContextKey).(*ConnContext)
+	if holder, ok := req.Context().Value(replayCtxKey).(**Flow); ok {
+		*holder = f
+		f.IsReplay = true
+		f.UseSeparateClient = true
+	}
+	f.Timing.ClientConnect = f.ConnContext.ClientConn.connectTime
+	f.Timing.RequestHeaders = f.StartTime
 	defer f.finish()
 
 	f.ConnContext.FlowCount.Add(1)
@@ -563,6 +714,7 @@ func (a *attacker) attack(res http.ResponseWriter, req *http.Request) {
 	}
 
 	proxyReqCtx := context.WithValue(req.Context(), proxyReqCtxKey, req)
+	proxyReqCtx = withFlowTiming(proxyReqCtx, f.Timing)
 	proxyReq, err := http.NewRequestWithContext(proxyReqCtx, f.Request.Method, f.Request.URL.String(), reqBody)
 	if err != nil {
 		for _, addon := range proxy.Addons {
@@ -577,6 +729,10 @@ func (a *attacker) attack(res http.ResponseWriter, req *http.Request) {
 			proxyReq.Header.Add(key, v)
 		}
 	}
+	// the Host header may differ from the dialed original destination in transparent and socks mode
+	if f.Request.URL.Host == rawReqUrlHost && req.Host != "" {
+		proxyReq.Host = req.Host
+	}
 
 	useSeparateClient := f.UseSeparateClient
 	if !useSeparateClient {
@@ -587,7 +743,7 @@ func (a *attacker) attack(res http.ResponseWriter, req *http.Request) {
 
 	var proxyRes *http.Response
 	if useSeparateClient {
-		proxyRes, err = a.client.Do(proxyReq)
+		proxyRes, err = a.separateClient(proxyReq.URL).Do(proxyReq)
 	} else {
 		if f.ConnContext.ServerConn == nil && f.ConnContext.dialFn != nil {
 			if err := f.ConnContext.dialFn(req.Context()); err != nil {
@@ -604,6 +760,7 @@ func (a *attacker) attack(res http.ResponseWriter, req *http.Request) {
 			}
 		}
 		proxyRes, err = f.ConnContext.ServerConn.client.Do(proxyReq)
+		f.Timing.fillServerConn(f.ConnContext.ServerConn)
 	}
 	if err != nil {
 		logErr(log, err)
@@ -665,6 +822,7 @@ func (a *attacker) attack(res http.ResponseWriter, req *http.Request) {
 			log.Warnf("response body size >= %v\n", proxy.Opts.StreamLargeBodies)
 			f.Stream = true
 		} else {
+			f.Timing.ResponseComplete = time.Now()
 			f.Response.Body = resBuf
 
 			// trigger addon event Response
@@ -684,4 +842,7 @@ func (a *attacker) attack(res http.ResponseWriter, req *http.Request) {
 	}
 
 	reply(f.Response, resBody)
+	if f.Timing.ResponseComplete.IsZero() {
+		f.Timing.ResponseComplete = time.Now()
+	}
 }
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/cert"
+)
+
+type testConnCA struct {
+	*cert.SelfSignCA
+	serverNames chan string
+	upstreams   chan *x509.Certificate
+}
+
+func (ca *testConnCA) GetCertForConn(chi *tls.ClientHelloInfo, upstream *x509.Certificate) (*tls.Certificate, error) {
+	ca.serverNames <- chi.ServerName
+	ca.upstreams <- upstream
+	return ca.SelfSignCA.GetCertForConn(chi, upstream)
+}
+
+func TestConnCA(t *testing.T) {
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29128",
+	}
+	helper.init(t)
+	defer helper.ln.Close()
+	go helper.server.Serve(helper.ln)
+	defer helper.tlsPlainLn.Close()
+	go helper.server.Serve(helper.tlsLn)
+
+	selfSignCA, err := cert.NewSelfSignCAMemory()
+	handleError(t, err)
+	ca := &testConnCA{
+		SelfSignCA:  selfSignCA.(*cert.SelfSignCA),
+		serverNames: make(chan string, 1),
+		upstreams:   make(chan *x509.Certificate, 1),
+	}
+	testProxy, err := NewProxy(&Options{
+		Addr:        ":29129",
+		SslInsecure: true,
+		NewCaFunc:   func() (cert.CA, error) { return ca, nil },
+	})
+	handleError(t, err)
+	go testProxy.Start()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 50) // wait for test proxy startup
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+			Proxy: func(r *http.Request) (*url.URL, error) {
+				return url.Parse("http://127.0.0.1:29129")
+			},
+		},
+	}
+	testSendRequest(t, helper.httpsEndpoint, client, "ok")
+
+	if serverName := <-ca.serverNames; serverName != "localhost" {
+		t.Fatalf("expected server name localhost, got %v", serverName)
+	}
+	upstream := <-ca.upstreams
+	if upstream == nil || upstream.DNSNames[0] != "localhost" {
+		t.Fatalf("expected upstream certificate of localhost, got %v", upstream)
+	}
+}
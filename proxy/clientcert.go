@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"path/filepath"
+
+	"github.com/lqqyt2423/go-mitmproxy/internal/helper"
+)
+
+// UpstreamClientCert client certificate presented to the upstream servers of Hosts, for mutual TLS
+type UpstreamClientCert struct {
+	Hosts    []string // host patterns matched by helper.MatchHost, such as *.example.com, example.com:8443
+	CertFile string   // PEM file of certificate chain, could also contain the private key
+	KeyFile  string   // PEM file of private key, default read from CertFile
+
+	cert *tls.Certificate
+}
+
+// LoadUpstreamClientCerts read client certificates from json or yaml config file:
+//
+//	{"Items": [{"Hosts": ["api.example.com:443"], "CertFile": "client.pem", "KeyFile": "client-key.pem"}]}
+//
+// relative CertFile and KeyFile are relative to the config file
+func LoadUpstreamClientCerts(filename string) ([]*UpstreamClientCert, error) {
+	var config struct {
+		Items []*UpstreamClientCert
+	}
+	if err := helper.NewStructFromFile(filename, &config); err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(filename)
+	for i, c := range config.Items {
+		if len(c.Hosts) == 0 {
+			return nil, fmt.Errorf("%v empty Hosts", i)
+		}
+		if c.CertFile == "" {
+			return nil, fmt.Errorf("%v empty CertFile", i)
+		}
+		if !filepath.IsAbs(c.CertFile) {
+			c.CertFile = filepath.Join(dir, c.CertFile)
+		}
+		if c.KeyFile != "" && !filepath.IsAbs(c.KeyFile) {
+			c.KeyFile = filepath.Join(dir, c.KeyFile)
+		}
+		if err := c.load(); err != nil {
+			return nil, err
+		}
+	}
+	return config.Items, nil
+}
+
+func (c *UpstreamClientCert) load() error {
+	keyFile := c.KeyFile
+	if keyFile == "" {
+		keyFile = c.CertFile
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("load client certificate %v error: %w", c.CertFile, err)
+	}
+	c.cert = &cert
+	return nil
+}
+
+// the first client certificate matched the address, nil if not matched
+func (proxy *Proxy) upstreamClientCert(address string) *UpstreamClientCert {
+	for _, c := range proxy.Opts.UpstreamClientCerts {
+		if helper.MatchHost(address, c.Hosts) {
+			return c
+		}
+	}
+	return nil
+}
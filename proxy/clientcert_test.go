@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/cert"
+)
+
+// https server requires client certificate, return its endpoint and client certificates config
+func newClientCertServer(t *testing.T) (string, []*UpstreamClientCert) {
+	t.Helper()
+	ca, err := cert.NewSelfSignCAMemory()
+	handleError(t, err)
+	serverCert, err := ca.GetCert("localhost")
+	handleError(t, err)
+	clientCert, err := ca.GetCert("client")
+	handleError(t, err)
+
+	// write client certificate and key to config dir
+	dir := t.TempDir()
+	certFile, err := os.Create(filepath.Join(dir, "client.pem"))
+	handleError(t, err)
+	handleError(t, pem.Encode(certFile, &pem.Block{Type: "CERTIFICATE", Bytes: clientCert.Certificate[0]}))
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(clientCert.PrivateKey)
+	handleError(t, err)
+	handleError(t, pem.Encode(certFile, &pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}))
+	certFile.Close()
+	configFile := filepath.Join(dir, "client_certs.yaml")
+	handleError(t, os.WriteFile(configFile, []byte("Items:\n  - Hosts: [localhost]\n    CertFile: client.pem\n"), 0644))
+	clientCerts, err := LoadUpstreamClientCerts(configFile)
+	handleError(t, err)
+
+	// https server requires client certificate
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.GetRootCA())
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{*serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})
+	handleError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	endpoint := "https://localhost:" + strconv.Itoa(ln.Addr().(*net.TCPAddr).Port) + "/"
+
+	return endpoint, clientCerts
+}
+
+func TestUpstreamClientCert(t *testing.T) {
+	endpoint, clientCerts := newClientCertServer(t)
+
+	testProxy, err := NewProxy(&Options{
+		Addr:                ":29130",
+		SslInsecure:         true,
+		UpstreamClientCerts: clientCerts,
+	})
+	handleError(t, err)
+	go testProxy.Start()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 50) // wait for test proxy startup
+
+	t.Run("intercept", func(t *testing.T) {
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true,
+				},
+				Proxy: func(r *http.Request) (*url.URL, error) {
+					return url.Parse("http://127.0.0.1:29130")
+				},
+			},
+		}
+		testSendRequest(t, endpoint, client, "client")
+	})
+
+	t.Run("matched by sni of connect to ip", func(t *testing.T) {
+		u, err := url.Parse(endpoint)
+		handleError(t, err)
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					ServerName:         "localhost",
+					InsecureSkipVerify: true,
+				},
+				Proxy: func(r *http.Request) (*url.URL, error) {
+					return url.Parse("http://127.0.0.1:29130")
+				},
+			},
+		}
+		testSendRequest(t, "https://127.0.0.1:"+u.Port()+"/", client, "client")
+	})
+
+	t.Run("separate client", func(t *testing.T) {
+		u, err := url.Parse(endpoint)
+		handleError(t, err)
+		f, err := testProxy.Replay(&Request{Method: "GET", URL: u, Header: http.Header{}})
+		handleError(t, err)
+		if f.Response == nil || string(f.Response.Body) != "client" {
+			t.Fatalf("expected client, but got %+v", f.Response)
+		}
+	})
+}
+
+func TestForwardClientCert(t *testing.T) {
+	endpoint, clientCerts := newClientCertServer(t)
+
+	// certificate of device, presented by client to proxy
+	ca, err := cert.NewSelfSignCAMemory()
+	handleError(t, err)
+	deviceCert, err := ca.GetCert("device")
+	handleError(t, err)
+
+	testProxy, err := NewProxy(&Options{
+		Addr:        ":29131",
+		SslInsecure: true,
+		ClientAuth:  tls.RequireAnyClientCert,
+	})
+	handleError(t, err)
+	testProxy.SetForwardClientCert(func(connCtx *ConnContext) (*tls.Certificate, error) {
+		peers := connCtx.ClientConn.PeerCertificates
+		if len(peers) > 0 && peers[0].Subject.CommonName == "device" {
+			return clientCerts[0].cert, nil
+		}
+		return nil, nil
+	})
+	go testProxy.Start()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 50) // wait for test proxy startup
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+				Certificates:       []tls.Certificate{*deviceCert},
+			},
+			Proxy: func(r *http.Request) (*url.URL, error) {
+				return url.Parse("http://127.0.0.1:29131")
+			},
+		},
+	}
+	testSendRequest(t, endpoint, client, "client")
+}
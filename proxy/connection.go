@@ -3,9 +3,11 @@ package proxy
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"net"
 	"net/http"
+	"time"
 
 	uuid "github.com/satori/go.uuid"
 	"go.uber.org/atomic"
@@ -17,8 +19,14 @@ type ClientConn struct {
 	Conn               net.Conn
 	Tls                bool
 	NegotiatedProtocol string
-	UpstreamCert       bool // Connect to upstream server to look up certificate details. Default: True
+	UpstreamCert       bool                // Connect to upstream server to look up certificate details. Default: True
+	PeerCertificates   []*x509.Certificate // client certificates, requested by Options.ClientAuth
+	JA3                string              // md5 of JA3 fingerprint of ClientHello
+	JA4                string              // JA4 fingerprint of ClientHello
 	clientHello        *tls.ClientHelloInfo
+	helloRecord        []byte // the first tls record sent by client, for Options.UpstreamClientHello mimic
+	originalDst        string // original destination address in transparent mode
+	connectTime        time.Time
 }
 
 func newClientConn(c net.Conn) *ClientConn {
@@ -27,6 +35,7 @@ func newClientConn(c net.Conn) *ClientConn {
 		Conn:         c,
 		Tls:          false,
 		UpstreamCert: true,
+		connectTime:  time.Now(),
 	}
 }
 
@@ -35,6 +44,17 @@ func (c *ClientConn) MarshalJSON() ([]byte, error) {
 	m["id"] = c.Id
 	m["tls"] = c.Tls
 	m["address"] = c.Conn.RemoteAddr().String()
+	if len(c.PeerCertificates) > 0 {
+		subjects := make([]string, len(c.PeerCertificates))
+		for i, cert := range c.PeerCertificates {
+			subjects[i] = cert.Subject.String()
+		}
+		m["peerCertificates"] = subjects
+	}
+	if c.JA3 != "" {
+		m["ja3"] = c.JA3
+		m["ja4"] = c.JA4
+	}
 	return json.Marshal(m)
 }
 
@@ -43,10 +63,15 @@ type ServerConn struct {
 	Id      uuid.UUID
 	Address string
 	Conn    net.Conn
+	JA3S    string // md5 of JA3S fingerprint of ServerHello
 
 	client   *http.Client
-	tlsConn  *tls.Conn
+	tlsConn  net.Conn // *tls.Conn, or *utls.UConn if Options.UpstreamClientHello is set
 	tlsState *tls.ConnectionState
+
+	dnsDoneTime          time.Time
+	connectDoneTime      time.Time
+	tlsHandshakeDoneTime time.Time
 }
 
 func newServerConn() *ServerConn {
@@ -55,6 +80,14 @@ func newServerConn() *ServerConn {
 	}
 }
 
+// leaf certificate of the upstream server, nil if not connected or not tls
+func (c *ServerConn) upstreamCert() *x509.Certificate {
+	if c == nil || c.tlsState == nil || len(c.tlsState.PeerCertificates) == 0 {
+		return nil
+	}
+	return c.tlsState.PeerCertificates[0]
+}
+
 func (c *ServerConn) MarshalJSON() ([]byte, error) {
 	m := make(map[string]interface{})
 	m["id"] = c.Id
@@ -64,6 +97,9 @@ func (c *ServerConn) MarshalJSON() ([]byte, error) {
 		peername = c.Conn.RemoteAddr().String()
 	}
 	m["peername"] = peername
+	if c.JA3S != "" {
+		m["ja3s"] = c.JA3S
+	}
 	return json.Marshal(m)
 }
 
@@ -84,6 +120,7 @@ type ConnContext struct {
 	proxy              *Proxy
 	closeAfterResponse bool                        // after http response, http server will close the connection
 	dialFn             func(context.Context) error // when begin request, if there no ServerConn, use this func to dial
+	authenticated      bool                        // authenticated by socks handshake, authProxy is skipped
 }
 
 func newConnContext(c net.Conn, proxy *Proxy) *ConnContext {
@@ -3,6 +3,8 @@ package proxy
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"encoding/binary"
 	"io"
 	"net"
 	"net/http"
@@ -52,7 +54,7 @@ type wrapClientConn struct {
 func newWrapClientConn(c net.Conn, proxy *Proxy) *wrapClientConn {
 	return &wrapClientConn{
 		Conn:      c,
-		r:         bufio.NewReader(c),
+		r:         bufio.NewReaderSize(c, maxTlsRecordSize),
 		proxy:     proxy,
 		closeChan: make(chan struct{}),
 	}
@@ -66,6 +68,22 @@ func (c *wrapClientConn) PeekBuffered() ([]byte, error) {
 	return c.r.Peek(c.r.Buffered())
 }
 
+// the max size of a tls record with header, the buffer of wrapClientConn could peek the whole ClientHello record
+const maxTlsRecordSize = 5 + 16*1024
+
+// peekClientHello parse ClientHello without consuming it, the ClientHello should be in the first tls record
+func (c *wrapClientConn) peekClientHello() (*tls.ClientHelloInfo, error) {
+	header, err := c.Peek(5)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.Peek(5 + int(binary.BigEndian.Uint16(header[3:5])))
+	if err != nil {
+		return nil, err
+	}
+	return helper.ParseClientHello(data)
+}
+
 func (c *wrapClientConn) Read(data []byte) (int, error) {
 	return c.r.Read(data)
 }
@@ -160,14 +178,57 @@ func (e *entry) start() error {
 		return err
 	}
 
+	socksAddr := e.proxy.Opts.SocksAddr
+	sharedSocks := socksAddr != "" && sameListenAddr(socksAddr, addr)
+	if socksAddr != "" && !sharedSocks {
+		socksLn, err := net.Listen("tcp", socksAddr)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		log.Infof("Proxy start socks listen at %v\n", socksAddr)
+		go func() {
+			err := e.server.Serve(newSniffListener(&wrapListener{Listener: socksLn, proxy: e.proxy}, e, true))
+			if err != http.ErrServerClosed {
+				log.Error(err)
+			}
+		}()
+	}
+
 	log.Infof("Proxy start listen at %v\n", e.server.Addr)
 	pln := &wrapListener{
 		Listener: ln,
 		proxy:    e.proxy,
 	}
+	if e.proxy.isSniffMode() || sharedSocks {
+		return e.server.Serve(newSniffListener(pln, e, sharedSocks))
+	}
 	return e.server.Serve(pln)
 }
 
+// sameListenAddr compare the resolved addresses, :8080 is the same as 0.0.0.0:8080
+func sameListenAddr(a, b string) bool {
+	if a == b {
+		return true
+	}
+	addrA, err := net.ResolveTCPAddr("tcp", a)
+	if err != nil {
+		return false
+	}
+	addrB, err := net.ResolveTCPAddr("tcp", b)
+	if err != nil {
+		return false
+	}
+	if addrA.Port != addrB.Port {
+		return false
+	}
+	unspecified := func(ip net.IP) bool { return ip == nil || ip.IsUnspecified() }
+	if unspecified(addrA.IP) || unspecified(addrB.IP) {
+		return unspecified(addrA.IP) && unspecified(addrB.IP)
+	}
+	return addrA.IP.Equal(addrB.IP)
+}
+
 func (e *entry) close() error {
 	return e.server.Close()
 }
@@ -183,8 +244,17 @@ func (e *entry) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 		"in":   "Proxy.entry.ServeHTTP",
 		"host": req.Host,
 	})
-	// Add entry proxy authentication
-	if e.proxy.authProxy != nil {
+
+	// reverse proxy, the client doesn't know it is being proxied
+	if proxy.isReverse() {
+		proxy.rewriteReverseURL(req)
+		proxy.attacker.initReverseDialFn(req.Context().Value(connContextKey).(*ConnContext))
+		proxy.attacker.attack(res, req)
+		return
+	}
+
+	// Add entry proxy authentication, socks clients have been authenticated in handshake
+	if e.proxy.authProxy != nil && !req.Context().Value(connContextKey).(*ConnContext).authenticated {
 		b, err := e.proxy.authProxy(res, req)
 		if !b {
 			log.Errorf("Proxy authentication failed: %s", err.Error())
@@ -198,6 +268,10 @@ func (e *entry) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if !req.URL.IsAbs() && (proxy.isTransparent() || req.Context().Value(connContextKey).(*ConnContext).ClientConn.originalDst != "") {
+		fillRelativeURL(req)
+	}
+
 	if !req.URL.IsAbs() || req.URL.Host == "" {
 		res = helper.NewResponseCheck(res)
 		for _, addon := range proxy.Addons {
@@ -225,7 +299,7 @@ func (e *entry) handleConnect(res http.ResponseWriter, req *http.Request) {
 		"host": req.Host,
 	})
 
-	shouldIntercept := proxy.shouldIntercept == nil || proxy.shouldIntercept(req)
+	shouldIntercept := (proxy.shouldIntercept == nil || proxy.shouldIntercept(req)) && !proxy.passthrough.Contains(req.Host)
 	f := newFlow()
 	f.Request = newRequest(req)
 	f.ConnContext = req.Context().Value(connContextKey).(*ConnContext)
@@ -243,7 +317,7 @@ func (e *entry) handleConnect(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if f.ConnContext.ClientConn.UpstreamCert {
+	if f.ConnContext.ClientConn.UpstreamCert && !proxy.forwardsClientCert() {
 		e.httpsDialFirstAttack(res, req, f)
 		return
 	}
@@ -341,7 +415,19 @@ func (e *entry) httpsDialFirstAttack(res http.ResponseWriter, req *http.Request,
 
 	if helper.IsTls(peek) {
 		f.ConnContext.ClientConn.Tls = true
-		proxy.attacker.httpsTlsDial(req.Context(), cconn, conn)
+		if !proxy.interceptTls(cconn.(*wrapClientConn)) {
+			f.ConnContext.Intercept = false
+			log.Debugf("begin transpond %v", req.Host)
+			transfer(log, conn, cconn)
+			cconn.Close()
+			conn.Close()
+			return
+		}
+		if err := proxy.attacker.httpsTlsDial(req.Context(), cconn, conn); err != nil {
+			for _, addon := range proxy.Addons {
+				addon.HTTPConnectError(f, err)
+			}
+		}
 		return
 	}
 
@@ -393,6 +479,20 @@ func (e *entry) httpsDialLazyAttack(res http.ResponseWriter, req *http.Request,
 
 	if helper.IsTls(peek) {
 		f.ConnContext.ClientConn.Tls = true
+		if !proxy.interceptTls(cconn.(*wrapClientConn)) {
+			f.ConnContext.Intercept = false
+			log.Debugf("begin transpond %v", req.Host)
+			conn, err := proxy.attacker.httpsDial(req.Context(), req)
+			if err != nil {
+				cconn.Close()
+				log.Error(err)
+				return
+			}
+			transfer(log, conn, cconn)
+			conn.Close()
+			cconn.Close()
+			return
+		}
 		proxy.attacker.httpsLazyAttack(req.Context(), cconn, req)
 		return
 	}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/lqqyt2423/go-mitmproxy/internal/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+// helloConn record the first tls record read from conn, the ClientHello of client or the ServerHello of server
+type helloConn struct {
+	net.Conn
+	buf  []byte
+	done bool
+}
+
+func (c *helloConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if !c.done && n > 0 {
+		c.buf = append(c.buf, p[:n]...)
+		if len(c.buf) >= 5 {
+			size := 5 + int(binary.BigEndian.Uint16(c.buf[3:5]))
+			if len(c.buf) >= size {
+				c.buf = c.buf[:size]
+				c.done = true
+			}
+		}
+	}
+	return n, err
+}
+
+// record the first tls record, nil if not complete
+func (c *helloConn) record() []byte {
+	if !c.done {
+		return nil
+	}
+	return c.buf
+}
+
+func (c *ClientConn) setFingerprint(hc *helloConn) {
+	c.helloRecord = hc.record()
+	ja3, ja4, err := helper.ClientHelloFingerprint(c.helloRecord)
+	if err != nil {
+		log.Debugf("client hello fingerprint error: %v", err)
+		return
+	}
+	c.JA3, c.JA4 = ja3, ja4
+}
+
+func (c *ServerConn) setFingerprint(hc *helloConn) {
+	ja3s, err := helper.ServerHelloFingerprint(hc.record())
+	if err != nil {
+		log.Debugf("server hello fingerprint error: %v", err)
+		return
+	}
+	c.JA3S = ja3s
+}
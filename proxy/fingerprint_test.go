@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fingerprintAddon struct {
+	BaseAddon
+	connCtxs chan *ConnContext
+}
+
+func (addon *fingerprintAddon) Requestheaders(f *Flow) {
+	addon.connCtxs <- f.ConnContext
+}
+
+func TestFingerprint(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	testProxy, err := NewProxy(&Options{
+		Addr:        ":29135",
+		SslInsecure: true,
+	})
+	handleError(t, err)
+	addon := &fingerprintAddon{connCtxs: make(chan *ConnContext, 10)}
+	testProxy.AddAddon(addon)
+	go testProxy.Start()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 50) // wait for test proxy startup
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+			Proxy: func(r *http.Request) (*url.URL, error) {
+				return url.Parse("http://127.0.0.1:29135")
+			},
+		},
+	}
+	testSendRequest(t, server.URL, client, "ok")
+
+	var connCtx *ConnContext
+	for connCtx = range addon.connCtxs {
+		if connCtx.ClientConn.Tls {
+			break
+		}
+	}
+	if len(connCtx.ClientConn.JA3) != 32 || !strings.HasPrefix(connCtx.ClientConn.JA4, "t13i") {
+		t.Fatalf("unexpected client fingerprint %v %v", connCtx.ClientConn.JA3, connCtx.ClientConn.JA4)
+	}
+	if len(connCtx.ServerConn.JA3S) != 32 {
+		t.Fatalf("unexpected server fingerprint %v", connCtx.ServerConn.JA3S)
+	}
+}
@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"io"
@@ -9,6 +10,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/gorilla/websocket"
 	uuid "github.com/satori/go.uuid"
 )
 
@@ -117,7 +119,9 @@ type Flow struct {
 	// 如果为 true，则不缓冲 Request.Body 和 Response.Body，且不进入之后的 Addon.Request 和 Addon.Response
 	Stream            bool
 	UseSeparateClient bool // use separate http client to send http request
+	IsReplay          bool // resent by Proxy.Replay
 	StartTime         time.Time
+	Timing            *Timing
 	done              chan struct{}
 }
 
@@ -125,6 +129,7 @@ func newFlow() *Flow {
 	return &Flow{
 		Id:        uuid.NewV4(),
 		StartTime: time.Now(),
+		Timing:    new(Timing),
 		done:      make(chan struct{}),
 	}
 }
@@ -142,14 +147,18 @@ func (f *Flow) MarshalJSON() ([]byte, error) {
 	j["id"] = f.Id
 	j["request"] = f.Request
 	j["response"] = f.Response
+	j["timing"] = f.Timing
+	j["isReplay"] = f.IsReplay
 	return json.Marshal(j)
 }
 
 type WebSocketMessage struct {
-	Type       int
-	Content    []byte
+	Type       int    // data frame, or control frame of ping, pong and close
+	Content    []byte // payload of close frame is the code and reason, see Close
 	FromClient bool
 	Timestamp  time.Time
+	Dropped    bool // set in Addon.WebSocketMessage to not forward the message
+	Injected   bool // sent by Flow.WebSocketInject
 }
 
 func (m *WebSocketMessage) MarshalJSON() ([]byte, error) {
@@ -158,15 +167,33 @@ func (m *WebSocketMessage) MarshalJSON() ([]byte, error) {
 		Content    string `json:"content"`    // base64 encoded
 		FromClient bool   `json:"fromClient"`
 		Timestamp  string `json:"timestamp"`
+		Dropped    bool   `json:"dropped,omitempty"`
+		Injected   bool   `json:"injected,omitempty"`
+		CloseCode  int    `json:"closeCode,omitempty"`
+		Reason     string `json:"closeReason,omitempty"`
 	}{
 		Type:       m.Type,
 		Content:    string(m.Content), // []byte 会被编码为 base64
 		FromClient: m.FromClient,
 		Timestamp:  m.Timestamp.Format(time.RFC3339Nano),
+		Dropped:    m.Dropped,
+		Injected:   m.Injected,
+	}
+	if m.Type == websocket.CloseMessage {
+		typeAlias.CloseCode, typeAlias.Reason = m.Close()
+		typeAlias.Content = ""
 	}
 	return json.Marshal(typeAlias)
 }
 
+// Close code and reason of close frame, 1005 if no status code
+func (m *WebSocketMessage) Close() (int, string) {
+	if len(m.Content) < 2 {
+		return websocket.CloseNoStatusReceived, ""
+	}
+	return int(binary.BigEndian.Uint16(m.Content)), string(m.Content[2:])
+}
+
 func newWebSocketMessage(msgType int, content []byte, fromClient bool) *WebSocketMessage {
 	return &WebSocketMessage{
 		Type:       msgType,
@@ -177,9 +204,18 @@ func newWebSocketMessage(msgType int, content []byte, fromClient bool) *WebSocke
 }
 
 type WebSocketData struct {
-	Messages []*WebSocketMessage
-
-	mu sync.Mutex
+	Messages    []*WebSocketMessage
+	CloseCode   int    // code of the first close frame, 1006 if the connection was closed without close frame
+	CloseReason string // reason of the first close frame, or the error of abnormal closure
+	ClosedBy    string // client or server, the side that closed first, empty if still open
+
+	mu     sync.Mutex
+	client *wsConn // connection with client, messages from server are written to it
+	server *wsConn
+
+	// serialize addMessage and Addon.WebSocketMessage of both directions, the last of Messages is the message being forwarded during the hooks
+	hookMu  sync.Mutex
+	pending []*WebSocketMessage // injected while hookMu is held, handled by the holder before releasing it
 }
 
 func newWebSocketData() *WebSocketData {
@@ -188,13 +224,28 @@ func newWebSocketData() *WebSocketData {
 	}
 }
 
-func (wsData *WebSocketData) addMessage(msgType int, content []byte, fromClient bool) {
-	msg := newWebSocketMessage(msgType, content, fromClient)
+// addMessage should be called with hookMu held
+func (wsData *WebSocketData) addMessage(msg *WebSocketMessage) {
 	wsData.mu.Lock()
 	defer wsData.mu.Unlock()
 	wsData.Messages = append(wsData.Messages, msg)
 }
 
+// setClosed record the first close only
+func (wsData *WebSocketData) setClosed(code int, reason string, fromClient bool) {
+	wsData.mu.Lock()
+	defer wsData.mu.Unlock()
+	if wsData.ClosedBy != "" {
+		return
+	}
+	wsData.CloseCode = code
+	wsData.CloseReason = reason
+	wsData.ClosedBy = "server"
+	if fromClient {
+		wsData.ClosedBy = "client"
+	}
+}
+
 // SSEEvent represents a single Server-Sent Event
 type SSEEvent struct {
 	ID      string    `json:"id,omitempty"`      // event id
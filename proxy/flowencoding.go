@@ -6,6 +6,7 @@ import (
 	"compress/gzip"
 	"errors"
 	"io"
+	"net/http"
 	"strconv"
 	"strings"
 
@@ -85,6 +86,65 @@ func (r *Response) ReplaceToDecodedBody() {
 	r.Header.Del("Transfer-Encoding")
 }
 
+// SetDecodedBody set the decoded body, encoded by the Content-Encoding of request if exists
+func (req *Request) SetDecodedBody(body []byte) {
+	req.Body = encodeBody(req.Header, body)
+}
+
+// SetDecodedBody set the decoded body, encoded by the Content-Encoding of response if exists
+func (r *Response) SetDecodedBody(body []byte) {
+	r.Body = encodeBody(r.Header, body)
+}
+
+// encode body by Content-Encoding header, remove the header if the encoding is not supported
+func encodeBody(header http.Header, body []byte) []byte {
+	enc := header.Get("Content-Encoding")
+	if enc != "" && enc != "identity" {
+		encodedBody, err := encode(enc, body)
+		if err != nil {
+			log.Error(err)
+			header.Del("Content-Encoding")
+		} else {
+			body = encodedBody
+		}
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	header.Del("Transfer-Encoding")
+	return body
+}
+
+func encode(enc string, body []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0))
+	var w io.WriteCloser
+	switch enc {
+	case "gzip":
+		w = gzip.NewWriter(buf)
+	case "br":
+		w = brotli.NewWriter(buf)
+	case "deflate":
+		fw, err := flate.NewWriter(buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		w = fw
+	case "zstd":
+		zw, err := zstd.NewWriter(buf)
+		if err != nil {
+			return nil, err
+		}
+		w = zw
+	default:
+		return nil, errEncodingNotSupport
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 func decode(enc string, body []byte) ([]byte, error) {
 	if enc == "gzip" {
 		dreader, err := gzip.NewReader(bytes.NewReader(body))
@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/internal/helper"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	log "github.com/sirupsen/logrus"
+)
+
+// http3Entry terminate HTTP/3 over QUIC from client.
+// The client connects to the udp listener directly (reverse mode, or traffic redirected to it),
+// so the destination is the :authority of request, or the backend in reverse mode.
+type http3Entry struct {
+	proxy  *Proxy
+	server *http3.Server
+
+	clientsMu sync.Mutex
+	clients   map[*UpstreamVerifyRule]*http.Client // upstream clients of verification rules, HTTP/3 or HTTP/2
+}
+
+func newHttp3Entry(proxy *Proxy) *http3Entry {
+	e := &http3Entry{
+		proxy:   proxy,
+		clients: make(map[*UpstreamVerifyRule]*http.Client),
+	}
+
+	e.server = &http3.Server{
+		Addr:    proxy.Opts.Http3Addr,
+		Handler: e,
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{
+			ClientAuth: proxy.Opts.ClientAuth,
+			GetCertificate: func(chi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+				name := chi.ServerName
+				if name == "" && proxy.isReverse() {
+					name = proxy.reverseUrl.Hostname()
+				}
+				return getCertForConn(proxy.attacker.ca, chi, name, nil)
+			},
+		}),
+		ConnContext: e.connContext,
+	}
+	return e
+}
+
+// upstreamClient the client of the verification rule matched the address, which is the ServerName with port
+func (e *http3Entry) upstreamClient(address string) *http.Client {
+	rule := e.proxy.upstreamVerifyRule(address)
+	e.clientsMu.Lock()
+	defer e.clientsMu.Unlock()
+	if c, ok := e.clients[rule]; ok {
+		return c
+	}
+
+	tlsConfig := &tls.Config{
+		KeyLogWriter: helper.GetTlsKeyLogWriter(),
+	}
+	e.proxy.setUpstreamVerify(tlsConfig, rule)
+	var transport http.RoundTripper
+	if e.proxy.Opts.Http3Upstream {
+		transport = &http3.Transport{
+			TLSClientConfig: tlsConfig,
+		}
+	} else {
+		// udp can't go through upstream proxy, but tcp can
+		transport = &http.Transport{
+			Proxy:              e.proxy.realUpstreamProxy(),
+			ForceAttemptHTTP2:  true,
+			DisableCompression: true,
+			TLSClientConfig:    tlsConfig,
+		}
+	}
+	c := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// 禁止自动重定向
+			return http.ErrUseLastResponse
+		},
+	}
+	e.clients[rule] = c
+	return c
+}
+
+func (e *http3Entry) closeClients() {
+	e.clientsMu.Lock()
+	defer e.clientsMu.Unlock()
+	for _, c := range e.clients {
+		if t, ok := c.Transport.(*http3.Transport); ok {
+			t.Close()
+		}
+	}
+}
+
+func (e *http3Entry) start() error {
+	conn, err := net.ListenPacket("udp", e.server.Addr)
+	if err != nil {
+		return err
+	}
+	log.Infof("Proxy start http3 listen at %v\n", e.server.Addr)
+	err = e.server.Serve(conn)
+	if errors.Is(err, http.ErrServerClosed) || errors.Is(err, quic.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (e *http3Entry) close() error {
+	err := e.server.Close()
+	e.closeClients()
+	return err
+}
+
+func (e *http3Entry) shutdown(ctx context.Context) error {
+	err := e.server.Shutdown(ctx)
+	e.closeClients()
+	return err
+}
+
+// called once for each quic connection
+func (e *http3Entry) connContext(ctx context.Context, c *quic.Conn) context.Context {
+	proxy := e.proxy
+	state := c.ConnectionState().TLS
+
+	connCtx := newConnContext(&quicConn{conn: c}, proxy)
+	connCtx.ClientConn.Tls = true
+	connCtx.ClientConn.NegotiatedProtocol = state.NegotiatedProtocol
+	connCtx.ClientConn.PeerCertificates = state.PeerCertificates
+	connCtx.Intercept = true
+
+	serverConn := newServerConn()
+	if proxy.isReverse() {
+		serverConn.Address = helper.CanonicalAddr(proxy.reverseUrl)
+		serverConn.client = e.upstreamClient(serverConn.Address)
+	} else if state.ServerName != "" {
+		// the port is unknown before request, updated by the :authority of the first request
+		serverConn.Address = net.JoinHostPort(state.ServerName, "443")
+	}
+	connCtx.ServerConn = serverConn
+
+	for _, addon := range proxy.Addons {
+		addon.ClientConnected(connCtx.ClientConn)
+	}
+	go func() {
+		<-c.Context().Done()
+		for _, addon := range proxy.Addons {
+			addon.ClientDisconnected(connCtx.ClientConn)
+		}
+	}()
+
+	return context.WithValue(ctx, connContextKey, connCtx)
+}
+
+func (e *http3Entry) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	req.URL.Scheme = "https"
+	if req.URL.Host == "" {
+		req.URL.Host = req.Host
+	}
+	if e.proxy.isReverse() {
+		e.proxy.rewriteReverseURL(req)
+	} else {
+		// the client may connect to a port other than 443, which is in :authority
+		connCtx := req.Context().Value(connContextKey).(*ConnContext)
+		connCtx.ClientConn.Conn.(*quicConn).addrOnce.Do(func() {
+			connCtx.ServerConn.Address = helper.CanonicalAddr(req.URL)
+			connCtx.ServerConn.client = e.upstreamClient(connCtx.ServerConn.Address)
+		})
+	}
+	e.proxy.attacker.attack(res, req)
+}
+
+// quicConn expose the addresses of quic connection as net.Conn for ClientConn,
+// the data is transferred by http3.Server through streams, read and write are not supported.
+type quicConn struct {
+	conn     *quic.Conn
+	addrOnce sync.Once // set the address of ServerConn once
+}
+
+var errQuicConnNotSupported = errors.New("read or write quic connection directly is not supported")
+
+func (c *quicConn) Read(b []byte) (int, error)         { return 0, errQuicConnNotSupported }
+func (c *quicConn) Write(b []byte) (int, error)        { return 0, errQuicConnNotSupported }
+func (c *quicConn) Close() error                       { return c.conn.CloseWithError(0, "") }
+func (c *quicConn) LocalAddr() net.Addr                { return c.conn.LocalAddr() }
+func (c *quicConn) RemoteAddr() net.Addr               { return c.conn.RemoteAddr() }
+func (c *quicConn) SetDeadline(t time.Time) error      { return nil }
+func (c *quicConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *quicConn) SetWriteDeadline(t time.Time) error { return nil }
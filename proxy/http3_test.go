@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+func TestHttp3(t *testing.T) {
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29119",
+	}
+	helper.init(t)
+	defer helper.ln.Close()
+	go helper.server.Serve(helper.ln)
+	defer helper.tlsPlainLn.Close()
+	go helper.server.Serve(helper.tlsLn)
+
+	// http3 backend with the same certificate
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	handleError(t, err)
+	h3Backend := &http3.Server{
+		Handler:   helper.server.Handler,
+		TLSConfig: http3.ConfigureTLSConfig(helper.server.TLSConfig.Clone()),
+	}
+	defer h3Backend.Close()
+	go h3Backend.Serve(udpConn)
+	h3Endpoint := "https://localhost:" + strconv.Itoa(udpConn.LocalAddr().(*net.UDPAddr).Port) + "/"
+
+	newHttp3Proxy := func(addr, http3Addr, mode string, http3Upstream bool) *Proxy {
+		p, err := NewProxy(&Options{
+			Addr:          addr,
+			SslInsecure:   true,
+			Mode:          mode,
+			Http3Addr:     http3Addr,
+			Http3Upstream: http3Upstream,
+		})
+		handleError(t, err)
+		p.AddAddon(&interceptAddon{})
+		return p
+	}
+
+	regularProxy := newHttp3Proxy(":29120", "127.0.0.1:29121", "", false)
+	addrAddon := &serverAddrAddon{}
+	regularProxy.AddAddon(addrAddon)
+	go regularProxy.Start()
+	defer regularProxy.Close()
+	reverseProxy := newHttp3Proxy(":29122", "127.0.0.1:29123", "reverse:"+helper.httpsEndpoint, false)
+	go reverseProxy.Start()
+	defer reverseProxy.Close()
+	h3UpstreamProxy := newHttp3Proxy(":29124", "127.0.0.1:29125", "reverse:"+h3Endpoint, true)
+	go h3UpstreamProxy.Start()
+	defer h3UpstreamProxy.Close()
+	time.Sleep(time.Millisecond * 50) // wait for test proxy startup
+
+	// dial to the http3 listener of proxy whatever the request host is, like redirected by firewall
+	newClient := func(http3Addr string) *http.Client {
+		transport := &http3.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+			Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+				return quic.DialAddrEarly(ctx, http3Addr, tlsCfg, cfg)
+			},
+		}
+		t.Cleanup(func() { transport.Close() })
+		return &http.Client{Transport: transport}
+	}
+
+	t.Run("transparent", func(t *testing.T) {
+		client := newClient("127.0.0.1:29121")
+		testSendRequest(t, helper.httpsEndpoint, client, "ok")
+		testSendRequest(t, helper.httpsEndpoint+"intercept-request", client, "intercept-request")
+		testSendRequest(t, helper.httpsEndpoint+"intercept-response", client, "intercept-response")
+		// the port of :authority, not 443
+		expected := "localhost:" + strconv.Itoa(helper.tlsPlainLn.Addr().(*net.TCPAddr).Port)
+		if addr := addrAddon.get(); addr != expected {
+			t.Fatalf("expected server address %v, got %v", expected, addr)
+		}
+	})
+
+	t.Run("reverse", func(t *testing.T) {
+		client := newClient("127.0.0.1:29123")
+		testSendRequest(t, "https://example.com/", client, "ok")
+	})
+
+	t.Run("http3 upstream", func(t *testing.T) {
+		client := newClient("127.0.0.1:29125")
+		testSendRequest(t, "https://example.com/", client, "ok")
+	})
+}
+
+// record the server address of the last request
+type serverAddrAddon struct {
+	BaseAddon
+	mu   sync.Mutex
+	addr string
+}
+
+func (addon *serverAddrAddon) Requestheaders(f *Flow) {
+	addon.mu.Lock()
+	defer addon.mu.Unlock()
+	addon.addr = f.ConnContext.ServerConn.Address
+}
+
+func (addon *serverAddrAddon) get() string {
+	addon.mu.Lock()
+	defer addon.mu.Unlock()
+	return addon.addr
+}
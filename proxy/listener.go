@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+
+	"github.com/lqqyt2423/go-mitmproxy/internal/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+// sniffListener is used in transparent and reverse mode or when socks is enabled, sniff every accepted connection:
+// socks and tls connections are handled directly, plain http connections are handed to entry.server
+type sniffListener struct {
+	*wrapListener
+	entry *entry
+	socks bool // accept socks4/4a/5 clients
+
+	connChan  chan net.Conn
+	done      chan struct{}
+	err       error
+	startOnce sync.Once
+}
+
+func newSniffListener(ln *wrapListener, e *entry, socks bool) *sniffListener {
+	return &sniffListener{
+		wrapListener: ln,
+		entry:        e,
+		socks:        socks,
+		connChan:     make(chan net.Conn),
+		done:         make(chan struct{}),
+	}
+}
+
+func (l *sniffListener) Accept() (net.Conn, error) {
+	l.startOnce.Do(func() {
+		go l.acceptLoop()
+	})
+	select {
+	case c := <-l.connChan:
+		return c, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+func (l *sniffListener) acceptLoop() {
+	for {
+		c, err := l.wrapListener.Accept()
+		if err != nil {
+			l.err = err
+			close(l.done)
+			return
+		}
+		go l.dispatch(c.(*wrapClientConn))
+	}
+}
+
+func (l *sniffListener) dispatch(cconn *wrapClientConn) {
+	log := log.WithFields(log.Fields{
+		"in":   "Proxy.sniffListener.dispatch",
+		"host": cconn.RemoteAddr().String(),
+	})
+
+	if l.proxy.isTransparent() {
+		setOriginalDst(cconn)
+	}
+
+	peek, err := cconn.Peek(3)
+	if err != nil {
+		cconn.Close()
+		logErr(log, err)
+		return
+	}
+
+	if l.socks && helper.IsSocks(peek) {
+		l.handleSocks(cconn)
+		return
+	}
+
+	if l.proxy.isSniffMode() && helper.IsTls(peek) {
+		if l.proxy.isReverse() {
+			l.entry.handleReverseTls(cconn)
+		} else {
+			l.entry.handleOriginalDstTls(cconn)
+		}
+		return
+	}
+
+	l.serve(cconn)
+}
+
+// hand plain http connection to entry.server
+func (l *sniffListener) serve(cconn *wrapClientConn) {
+	select {
+	case l.connChan <- cconn:
+	case <-l.done:
+		cconn.Close()
+	}
+}
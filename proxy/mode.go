@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	ModeRegular     = "regular"     // explicit http/https proxy
+	ModeTransparent = "transparent" // traffic redirected by iptables/nftables
+	ModeReverse     = "reverse"     // reverse:https://backend:8443/api, front a fixed upstream, the path is prefixed to requests
+)
+
+// parse Options.Mode, return the mode name and the backend url of reverse mode
+func parseMode(mode string) (string, *url.URL, error) {
+	switch mode {
+	case "", ModeRegular:
+		return ModeRegular, nil, nil
+	case ModeTransparent:
+		return ModeTransparent, nil, nil
+	}
+
+	if rawurl, ok := strings.CutPrefix(mode, ModeReverse+":"); ok {
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid reverse mode %v: %w", mode, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return "", nil, fmt.Errorf("invalid reverse mode %v: scheme should be http or https", mode)
+		}
+		if u.Host == "" {
+			return "", nil, fmt.Errorf("invalid reverse mode %v: empty host", mode)
+		}
+		return ModeReverse, u, nil
+	}
+
+	return "", nil, fmt.Errorf("invalid proxy mode %v", mode)
+}
+
+func (proxy *Proxy) isTransparent() bool {
+	return proxy.mode == ModeTransparent
+}
+
+func (proxy *Proxy) isReverse() bool {
+	return proxy.mode == ModeReverse
+}
+
+// whether the listener should sniff tls connections itself, instead of waiting for CONNECT
+func (proxy *Proxy) isSniffMode() bool {
+	return proxy.isTransparent() || proxy.isReverse()
+}
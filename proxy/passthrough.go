@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TlsPassthrough learn the hosts whose clients reject the certificate issued by proxy, such as certificate pinning.
+// Later connections of the learned hosts are transferred directly without interception, like tls_passthrough of mitmproxy.
+type TlsPassthrough struct {
+	threshold int    // failed client handshakes before passthrough
+	filename  string // persist the learned hosts, one host per line, empty to keep in memory only
+
+	mu       sync.RWMutex
+	hosts    map[string]bool
+	failures map[string]int
+}
+
+// NewTlsPassthrough threshold <= 0 is treated as 1, hosts in filename are loaded if the file exists
+func NewTlsPassthrough(threshold int, filename string) (*TlsPassthrough, error) {
+	if threshold <= 0 {
+		threshold = 1
+	}
+	tp := &TlsPassthrough{
+		threshold: threshold,
+		filename:  filename,
+		hosts:     make(map[string]bool),
+		failures:  make(map[string]int),
+	}
+	if filename == "" {
+		return tp, nil
+	}
+
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return tp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tp.hosts[passthroughHostname(line)] = true
+	}
+	return tp, nil
+}
+
+// passthroughHostname lower case hostname without port
+func passthroughHostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// Contains whether connections of host should be passed through, host could contain port
+func (tp *TlsPassthrough) Contains(host string) bool {
+	if tp == nil {
+		return false
+	}
+	tp.mu.RLock()
+	defer tp.mu.RUnlock()
+	return tp.hosts[passthroughHostname(host)]
+}
+
+// Hosts the sorted passthrough hosts
+func (tp *TlsPassthrough) Hosts() []string {
+	tp.mu.RLock()
+	defer tp.mu.RUnlock()
+	hosts := make([]string, 0, len(tp.hosts))
+	for h := range tp.hosts {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// Add the host to passthrough manually
+func (tp *TlsPassthrough) Add(host string) error {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.hosts[passthroughHostname(host)] = true
+	return tp.save()
+}
+
+// Remove the host to intercept it again
+func (tp *TlsPassthrough) Remove(host string) error {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	host = passthroughHostname(host)
+	delete(tp.hosts, host)
+	delete(tp.failures, host)
+	return tp.save()
+}
+
+// handshakeFailed return true if the host is added to passthrough
+func (tp *TlsPassthrough) handshakeFailed(host string) bool {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	host = passthroughHostname(host)
+	if tp.hosts[host] {
+		return false
+	}
+	tp.failures[host]++
+	if tp.failures[host] < tp.threshold {
+		return false
+	}
+	delete(tp.failures, host)
+	tp.hosts[host] = true
+	if err := tp.save(); err != nil {
+		log.Error(err)
+	}
+	return true
+}
+
+func (tp *TlsPassthrough) handshakeSucceeded(host string) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	delete(tp.failures, passthroughHostname(host))
+}
+
+// save with lock held
+func (tp *TlsPassthrough) save() error {
+	if tp.filename == "" {
+		return nil
+	}
+	hosts := make([]string, 0, len(tp.hosts))
+	for h := range tp.hosts {
+		hosts = append(hosts, h+"\n")
+	}
+	sort.Strings(hosts)
+
+	tmp, err := os.CreateTemp(filepath.Dir(tp.filename), filepath.Base(tp.filename)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.WriteString(strings.Join(hosts, "")); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), tp.filename)
+}
+
+// TlsPassthrough nil if Options.PassthroughFailures is not set
+func (proxy *Proxy) TlsPassthrough() *TlsPassthrough {
+	return proxy.passthrough
+}
+
+// tlsClientFailed the client closed the handshake after ClientHello, maybe rejected the certificate
+func (proxy *Proxy) tlsClientFailed(connCtx *ConnContext, err error) {
+	if proxy.passthrough == nil || connCtx.ClientConn.clientHello == nil || connCtx.ClientConn.clientHello.ServerName == "" {
+		return
+	}
+	host := connCtx.ClientConn.clientHello.ServerName
+	if proxy.passthrough.handshakeFailed(host) {
+		log.Warnf("passthrough %v, client tls handshake failed: %v", host, err)
+	}
+}
+
+func (proxy *Proxy) tlsClientSucceeded(connCtx *ConnContext) {
+	if proxy.passthrough == nil || connCtx.ClientConn.clientHello == nil {
+		return
+	}
+	proxy.passthrough.handshakeSucceeded(connCtx.ClientConn.clientHello.ServerName)
+}
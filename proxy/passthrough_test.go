@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/cert"
+)
+
+func TestTlsPassthrough(t *testing.T) {
+	ca, err := cert.NewSelfSignCAMemory()
+	handleError(t, err)
+	serverCert, err := ca.GetCert("localhost")
+	handleError(t, err)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{*serverCert}})
+	handleError(t, err)
+	defer ln.Close()
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	endpoint := "https://localhost:" + strconv.Itoa(ln.Addr().(*net.TCPAddr).Port) + "/"
+
+	filename := filepath.Join(t.TempDir(), "passthrough.txt")
+	testProxy, err := NewProxy(&Options{
+		Addr:                ":29133",
+		SslInsecure:         true,
+		PassthroughFailures: 1,
+		PassthroughFile:     filename,
+	})
+	handleError(t, err)
+	go testProxy.Start()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 50) // wait for test proxy startup
+
+	// the client only trusts the server, like certificate pinning
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ca.GetRootCA())
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: rootCAs,
+			},
+			Proxy: func(r *http.Request) (*url.URL, error) {
+				return url.Parse("http://127.0.0.1:29133")
+			},
+			DisableKeepAlives: true,
+		},
+	}
+
+	if _, err := client.Get(endpoint); err == nil {
+		t.Fatal("expected certificate error of intercepting")
+	}
+	for i := 0; i < 20 && !testProxy.TlsPassthrough().Contains("localhost"); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if hosts := testProxy.TlsPassthrough().Hosts(); len(hosts) != 1 || hosts[0] != "localhost" {
+		t.Fatalf("expected localhost learned, but got %v", hosts)
+	}
+	data, err := os.ReadFile(filename)
+	handleError(t, err)
+	if string(data) != "localhost\n" {
+		t.Fatalf("unexpected persisted hosts %q", data)
+	}
+
+	testSendRequest(t, endpoint, client, "ok")
+
+	// load the persisted hosts
+	tp, err := NewTlsPassthrough(1, filename)
+	handleError(t, err)
+	if !tp.Contains("localhost:443") {
+		t.Fatal("expected localhost loaded")
+	}
+	handleError(t, tp.Remove("localhost"))
+	tp, err = NewTlsPassthrough(1, filename)
+	handleError(t, err)
+	if len(tp.Hosts()) != 0 {
+		t.Fatalf("expected no hosts, but got %v", tp.Hosts())
+	}
+}
+
+func TestShouldInterceptTlsRule(t *testing.T) {
+	ca, err := cert.NewSelfSignCAMemory()
+	handleError(t, err)
+	serverCert, err := ca.GetCert("localhost")
+	handleError(t, err)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{*serverCert}})
+	handleError(t, err)
+	defer ln.Close()
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	// CONNECT to ip address, the hostname is only in SNI
+	endpoint := "https://" + ln.Addr().String() + "/"
+
+	testProxy, err := NewProxy(&Options{
+		Addr:        ":29134",
+		SslInsecure: true,
+	})
+	handleError(t, err)
+	testProxy.SetShouldInterceptTlsRule(func(chi *tls.ClientHelloInfo) bool {
+		return chi.ServerName != "localhost"
+	})
+	go testProxy.Start()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 50) // wait for test proxy startup
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ca.GetRootCA())
+	newClient := func(serverName string) *http.Client {
+		return &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					ServerName:         serverName,
+					RootCAs:            rootCAs,
+					InsecureSkipVerify: serverName != "localhost",
+				},
+				Proxy: func(r *http.Request) (*url.URL, error) {
+					return url.Parse("http://127.0.0.1:29134")
+				},
+			},
+		}
+	}
+
+	t.Run("passthrough by sni", func(t *testing.T) {
+		resp, err := newClient("localhost").Get(endpoint)
+		handleError(t, err)
+		defer resp.Body.Close()
+		if err := resp.TLS.PeerCertificates[0].CheckSignatureFrom(ca.GetRootCA()); err != nil {
+			t.Fatalf("expected certificate of server, but got %v", err)
+		}
+	})
+
+	t.Run("passthrough by sni of client hello larger than 4096 bytes", func(t *testing.T) {
+		client := newClient("localhost")
+		protos := make([]string, 0, 41)
+		for i := 0; i < 40; i++ {
+			protos = append(protos, fmt.Sprintf("%03d%s", i, strings.Repeat("x", 200)))
+		}
+		client.Transport.(*http.Transport).TLSClientConfig.NextProtos = append(protos, "http/1.1")
+		resp, err := client.Get(endpoint)
+		handleError(t, err)
+		defer resp.Body.Close()
+		if err := resp.TLS.PeerCertificates[0].CheckSignatureFrom(ca.GetRootCA()); err != nil {
+			t.Fatalf("expected certificate of server, but got %v", err)
+		}
+	})
+
+	t.Run("intercept", func(t *testing.T) {
+		resp, err := newClient("example.com").Get(endpoint)
+		handleError(t, err)
+		defer resp.Body.Close()
+		if resp.TLS.PeerCertificates[0].CheckSignatureFrom(ca.GetRootCA()) == nil {
+			t.Fatal("expected certificate issued by proxy")
+		}
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"fmt"
 	"net"
 	"net/http"
 	"net/url"
@@ -19,9 +20,23 @@ type Options struct {
 	StreamLargeBodies int64 // 当请求或响应体大于此字节时，转为 stream 模式
 	SslInsecure       bool
 	CaRootPath        string
+	CertWildcard      bool                    // issue *.parent certificates of the self sign ca
+	CaOptions         *cert.SelfSignCAOptions // key types, validity and cache of the self sign ca, could be nil
 	NewCaFunc         func() (cert.CA, error) //创建 Ca 的函数
 	Upstream          string
 	LogFilePath       string // Path to write logs to file
+	Mode              string // regular(default), transparent or reverse:https://backend:8443
+	SocksAddr         string // socks4/4a/5 listen addr, the same as Addr to share the listener
+	Http3Addr         string // udp listen addr of HTTP/3, empty to disable
+	Http3Upstream     bool   // forward HTTP/3 requests to upstream over HTTP/3 instead of HTTP/2
+
+	UpstreamClientCerts []*UpstreamClientCert // client certificates of mutual TLS with upstream servers
+	ClientAuth          tls.ClientAuthType    // request client certificate when intercepting, tls.RequestClientCert or tls.RequireAnyClientCert
+	UpstreamRootCAs     *x509.CertPool        // roots to verify upstream certificates, nil to use the system roots
+	UpstreamVerifyRules []*UpstreamVerifyRule // per host verification policy of upstream, such as insecure and pins
+	PassthroughFailures int                   // passthrough the host after client tls handshake failed times, 0 to disable
+	PassthroughFile     string                // persist the learned passthrough hosts
+	UpstreamClientHello string                // ClientHello sent to upstream: mimic the client, or browser profile chrome, firefox, safari, edge, ios. Empty to use crypto/tls
 }
 
 type Proxy struct {
@@ -30,25 +45,58 @@ type Proxy struct {
 	Addons  []Addon
 
 	entry            *entry
+	http3Entry       *http3Entry
 	attacker         *attacker
 	webSocketHandler *webSocketHandler
 	shouldIntercept  func(req *http.Request) bool              // req is received by proxy.server
+	interceptTlsRule func(chi *tls.ClientHelloInfo) bool       // chi is peeked from client before intercepting
 	upstreamProxy    func(req *http.Request) (*url.URL, error) // req is received by proxy.server, not client request
 	authProxy        func(res http.ResponseWriter, req *http.Request) (bool, error)
+	forwardCertFn    func(connCtx *ConnContext) (*tls.Certificate, error)
+	passthrough      *TlsPassthrough // hosts learned to transfer directly, nil if disabled
+	mode             string
+	reverseUrl       *url.URL // backend of reverse mode
 }
 
 // proxy.server req context key
 var proxyReqCtxKey = new(struct{})
 
 func NewProxy(opts *Options) (*Proxy, error) {
+	mode, reverseUrl, err := parseMode(opts.Mode)
+	if err != nil {
+		return nil, err
+	}
 	if opts.StreamLargeBodies <= 0 {
 		opts.StreamLargeBodies = 1024 * 1024 * 5 // default: 5mb
 	}
 
 	proxy := &Proxy{
-		Opts:    opts,
-		Version: "1.9.2",
-		Addons:  make([]Addon, 0),
+		Opts:       opts,
+		Version:    "1.9.2",
+		Addons:     make([]Addon, 0),
+		mode:       mode,
+		reverseUrl: reverseUrl,
+	}
+
+	if opts.ClientAuth != tls.NoClientCert && opts.ClientAuth != tls.RequestClientCert && opts.ClientAuth != tls.RequireAnyClientCert {
+		return nil, fmt.Errorf("unsupported ClientAuth %v, client certificates are not verified", opts.ClientAuth)
+	}
+	if err := validateUpstreamHello(opts.UpstreamClientHello); err != nil {
+		return nil, err
+	}
+	for _, c := range opts.UpstreamClientCerts {
+		if c.cert == nil {
+			if err := c.load(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if opts.PassthroughFailures > 0 {
+		proxy.passthrough, err = NewTlsPassthrough(opts.PassthroughFailures, opts.PassthroughFile)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	proxy.entry = newEntry(proxy)
@@ -61,6 +109,10 @@ func NewProxy(opts *Options) (*Proxy, error) {
 
 	proxy.webSocketHandler = newWebSocketHandler(proxy)
 
+	if opts.Http3Addr != "" {
+		proxy.http3Entry = newHttp3Entry(proxy)
+	}
+
 	return proxy, nil
 }
 
@@ -74,14 +126,27 @@ func (proxy *Proxy) Start() error {
 			log.Error(err)
 		}
 	}()
+	if proxy.http3Entry != nil {
+		go func() {
+			if err := proxy.http3Entry.start(); err != nil {
+				log.Error(err)
+			}
+		}()
+	}
 	return proxy.entry.start()
 }
 
 func (proxy *Proxy) Close() error {
+	if proxy.http3Entry != nil {
+		proxy.http3Entry.close()
+	}
 	return proxy.entry.close()
 }
 
 func (proxy *Proxy) Shutdown(ctx context.Context) error {
+	if proxy.http3Entry != nil {
+		proxy.http3Entry.shutdown(ctx)
+	}
 	return proxy.entry.shutdown(ctx)
 }
 
@@ -97,6 +162,31 @@ func (proxy *Proxy) SetShouldInterceptRule(rule func(req *http.Request) bool) {
 	proxy.shouldIntercept = rule
 }
 
+// SetShouldInterceptTlsRule decide by the ClientHello peeked from client, such as SNI and ALPN,
+// called after SetShouldInterceptRule allowed the CONNECT request, chi.Conn is the client connection.
+// Return false to transfer the tls connection to upstream directly.
+func (proxy *Proxy) SetShouldInterceptTlsRule(rule func(chi *tls.ClientHelloInfo) bool) {
+	proxy.interceptTlsRule = rule
+}
+
+// interceptTls apply the tls rule and the learned passthrough hosts by SNI,
+// intercept if the ClientHello could not be peeked
+func (proxy *Proxy) interceptTls(cconn *wrapClientConn) bool {
+	if proxy.interceptTlsRule == nil && proxy.passthrough == nil {
+		return true
+	}
+	chi, err := cconn.peekClientHello()
+	if err != nil {
+		log.Debugf("peek client hello error: %v", err)
+		return true
+	}
+	chi.Conn = cconn
+	if chi.ServerName != "" && proxy.passthrough.Contains(chi.ServerName) {
+		return false
+	}
+	return proxy.interceptTlsRule == nil || proxy.interceptTlsRule(chi)
+}
+
 func (proxy *Proxy) SetUpstreamProxy(fn func(req *http.Request) (*url.URL, error)) {
 	proxy.upstreamProxy = fn
 }
@@ -134,6 +224,18 @@ func (proxy *Proxy) getUpstreamConn(ctx context.Context, req *http.Request) (net
 	return conn, err
 }
 
+// SetForwardClientCert set the function returns the certificate presented to upstream server,
+// by the client certificates in connCtx.ClientConn.PeerCertificates, return nil to use Options.UpstreamClientCerts.
+// It works with Options.ClientAuth, the client handshake is finished before connecting to upstream.
+func (proxy *Proxy) SetForwardClientCert(fn func(connCtx *ConnContext) (*tls.Certificate, error)) {
+	proxy.forwardCertFn = fn
+}
+
+// client certificates are needed before upstream handshake
+func (proxy *Proxy) forwardsClientCert() bool {
+	return proxy.forwardCertFn != nil && proxy.Opts.ClientAuth != tls.NoClientCert
+}
+
 func (proxy *Proxy) SetAuthProxy(fn func(res http.ResponseWriter, req *http.Request) (bool, error)) {
 	proxy.authProxy = fn
 }
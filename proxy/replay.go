@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// replay request context key, the value is *Flow holder.
+// Not new(struct{}), which may share the same address with other zero-size keys.
+var replayCtxKey = new(byte)
+
+// Replay resend the request through the upstream client of attacker.
+// The replayed flow goes through all addons with Flow.IsReplay set, and is returned after finished.
+func (proxy *Proxy) Replay(req *Request) (*Flow, error) {
+	if req == nil || req.URL == nil || !req.URL.IsAbs() || req.URL.Host == "" {
+		return nil, errors.New("replay request should have absolute url")
+	}
+
+	connCtx := newConnContext(&replayConn{}, proxy)
+	connCtx.ClientConn.Tls = req.URL.Scheme == "https"
+	connCtx.Intercept = true
+	for _, addon := range proxy.Addons {
+		addon.ClientConnected(connCtx.ClientConn)
+	}
+	defer func() {
+		for _, addon := range proxy.Addons {
+			addon.ClientDisconnected(connCtx.ClientConn)
+		}
+	}()
+
+	var holder *Flow
+	ctx := context.WithValue(context.Background(), connContextKey, connCtx)
+	ctx = context.WithValue(ctx, replayCtxKey, &holder)
+	hreq, err := http.NewRequestWithContext(ctx, req.Method, req.URL.String(), bytes.NewReader(req.Body))
+	if err != nil {
+		return nil, err
+	}
+	if req.Header != nil {
+		hreq.Header = req.Header.Clone()
+	}
+	if req.Proto != "" {
+		hreq.Proto = req.Proto
+	}
+
+	proxy.attacker.attack(&discardResponseWriter{header: make(http.Header)}, hreq)
+	if holder == nil {
+		return nil, errors.New("replay failed")
+	}
+	return holder, nil
+}
+
+// replayConn is the client connection of replayed requests, no data is transferred
+type replayConn struct{}
+
+type replayAddr struct{}
+
+func (replayAddr) Network() string { return "replay" }
+func (replayAddr) String() string  { return "replay" }
+
+func (c *replayConn) Read(b []byte) (int, error)         { return 0, net.ErrClosed }
+func (c *replayConn) Write(b []byte) (int, error)        { return 0, net.ErrClosed }
+func (c *replayConn) Close() error                       { return nil }
+func (c *replayConn) LocalAddr() net.Addr                { return replayAddr{} }
+func (c *replayConn) RemoteAddr() net.Addr               { return replayAddr{} }
+func (c *replayConn) SetDeadline(t time.Time) error      { return nil }
+func (c *replayConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *replayConn) SetWriteDeadline(t time.Time) error { return nil }
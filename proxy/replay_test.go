@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+type testReplayAddon struct {
+	BaseAddon
+	replayed chan bool
+}
+
+func (a *testReplayAddon) Requestheaders(f *Flow) {
+	a.replayed <- f.IsReplay
+}
+
+func TestReplay(t *testing.T) {
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29127",
+	}
+	helper.init(t)
+	defer helper.ln.Close()
+	go helper.server.Serve(helper.ln)
+	defer helper.tlsPlainLn.Close()
+	go helper.server.Serve(helper.tlsLn)
+	testProxy := helper.testProxy
+	replayAddon := &testReplayAddon{replayed: make(chan bool, 1)}
+	testProxy.AddAddon(replayAddon)
+
+	replay := func(t *testing.T, rawurl string, bodyWant string) {
+		t.Helper()
+		u, err := url.Parse(rawurl)
+		handleError(t, err)
+		f, err := testProxy.Replay(&Request{Method: "GET", URL: u, Header: http.Header{}})
+		handleError(t, err)
+		if !<-replayAddon.replayed || !f.IsReplay {
+			t.Fatal("flow should be marked as replayed")
+		}
+		if f.Response == nil || string(f.Response.Body) != bodyWant {
+			t.Fatalf("expected %v, but got %+v", bodyWant, f.Response)
+		}
+	}
+
+	t.Run("http", func(t *testing.T) {
+		replay(t, helper.httpEndpoint, "ok")
+		replay(t, helper.httpEndpoint+"intercept-response", "intercept-response")
+	})
+	t.Run("https", func(t *testing.T) {
+		replay(t, helper.httpsEndpoint, "ok")
+	})
+	t.Run("relative url", func(t *testing.T) {
+		if _, err := testProxy.Replay(&Request{Method: "GET", URL: &url.URL{Path: "/"}}); err == nil {
+			t.Fatal("expected error of relative url")
+		}
+	})
+}
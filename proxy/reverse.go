@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/lqqyt2423/go-mitmproxy/internal/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+// handle tls connection in reverse mode, terminate the client tls then forward to the backend
+func (e *entry) handleReverseTls(cconn *wrapClientConn) {
+	proxy := e.proxy
+	connCtx := cconn.connCtx
+	connCtx.ClientConn.Tls = true
+	connCtx.Intercept = true
+
+	req := newReverseConnectRequest(connCtx, proxy.reverseUrl)
+	proxy.attacker.httpsLazyAttack(req.Context(), cconn, req)
+}
+
+// newReverseConnectRequest create a fake CONNECT request to the backend,
+// so that the dial functions of attacker could be reused.
+func newReverseConnectRequest(connCtx *ConnContext, backend *url.URL) *http.Request {
+	ctx := context.WithValue(context.Background(), connContextKey, connCtx)
+	req, _ := http.NewRequestWithContext(ctx, "CONNECT", "", nil)
+	req.URL = &url.URL{Scheme: backend.Scheme, Host: backend.Host}
+	req.Host = helper.CanonicalAddr(backend)
+	return req
+}
+
+// the client doesn't know it is being proxied, rewrite the request to the backend,
+// the path and query of backend are joined to the request, like httputil.NewSingleHostReverseProxy
+func (proxy *Proxy) rewriteReverseURL(req *http.Request) {
+	backend := proxy.reverseUrl
+	log.Debugf("reverse %v to %v", req.URL.String(), backend.String())
+	req.URL.Scheme = backend.Scheme
+	req.URL.Host = backend.Host
+	req.URL.Path, req.URL.RawPath = joinURLPath(backend, req.URL)
+	if backend.RawQuery == "" || req.URL.RawQuery == "" {
+		req.URL.RawQuery = backend.RawQuery + req.URL.RawQuery
+	} else {
+		req.URL.RawQuery = backend.RawQuery + "&" + req.URL.RawQuery
+	}
+	req.Host = backend.Host
+}
+
+func joinURLPath(a, b *url.URL) (path, rawpath string) {
+	if a.RawPath == "" && b.RawPath == "" {
+		return singleJoiningSlash(a.Path, b.Path), ""
+	}
+	apath := a.EscapedPath()
+	bpath := b.EscapedPath()
+	path = singleJoiningSlash(a.Path, b.Path)
+	rawpath = singleJoiningSlash(apath, bpath)
+	if rawpath == path {
+		rawpath = ""
+	}
+	return path, rawpath
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash && a != "" && b != "":
+		return a + "/" + b
+	case a == "" && b == "":
+		return a
+	}
+	return a + b
+}
+
+func (a *attacker) initReverseDialFn(connCtx *ConnContext) {
+	req := newReverseConnectRequest(connCtx, a.proxy.reverseUrl)
+	if a.proxy.reverseUrl.Scheme == "https" {
+		a.initHttpsDialFn(req)
+		return
+	}
+	a.initHttpDialFn(req)
+}
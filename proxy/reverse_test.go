@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReverseMode(t *testing.T) {
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29111",
+	}
+	helper.init(t)
+	defer helper.ln.Close()
+	go helper.server.Serve(helper.ln)
+	defer helper.tlsPlainLn.Close()
+	go helper.server.Serve(helper.tlsLn)
+
+	newReverseProxy := func(addr string, backend string) *Proxy {
+		p, err := NewProxy(&Options{
+			Addr:        addr,
+			SslInsecure: true,
+			Mode:        "reverse:" + backend,
+		})
+		handleError(t, err)
+		p.AddAddon(&interceptAddon{})
+		return p
+	}
+
+	httpBackendProxy := newReverseProxy(":29112", helper.httpEndpoint)
+	go httpBackendProxy.Start()
+	defer httpBackendProxy.Close()
+	httpsBackendProxy := newReverseProxy(":29113", helper.httpsEndpoint)
+	go httpsBackendProxy.Start()
+	defer httpsBackendProxy.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+		},
+	}
+
+	t.Run("http backend", func(t *testing.T) {
+		t.Run("http client", func(t *testing.T) {
+			testSendRequest(t, "http://127.0.0.1:29112/", client, "ok")
+			testSendRequest(t, "http://127.0.0.1:29112/intercept-request", client, "intercept-request")
+		})
+		t.Run("https client", func(t *testing.T) {
+			testSendRequest(t, "https://127.0.0.1:29112/", client, "ok")
+			testSendRequest(t, "https://127.0.0.1:29112/intercept-response", client, "intercept-response")
+		})
+	})
+
+	t.Run("https backend", func(t *testing.T) {
+		t.Run("http client", func(t *testing.T) {
+			testSendRequest(t, "http://127.0.0.1:29113/", client, "ok")
+		})
+		t.Run("https client", func(t *testing.T) {
+			testSendRequest(t, "https://localhost:29113/", client, "ok")
+		})
+	})
+
+	t.Run("invalid mode", func(t *testing.T) {
+		for _, mode := range []string{"reverse:", "reverse:ftp://backend", "unknown"} {
+			if _, err := NewProxy(&Options{Mode: mode}); err == nil {
+				t.Fatalf("expected error of mode %v", mode)
+			}
+		}
+	})
+}
+
+func TestReverseModeBackendPath(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.RequestURI()))
+	}))
+	defer backend.Close()
+
+	p, err := NewProxy(&Options{
+		Addr: "127.0.0.1:29146",
+		Mode: "reverse:" + backend.URL + "/api?key=v",
+	})
+	handleError(t, err)
+	go p.Start()
+	defer p.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	testSendRequest(t, "http://127.0.0.1:29146/users?id=1", http.DefaultClient, "/api/users?key=v&id=1")
+	testSendRequest(t, "http://127.0.0.1:29146/", http.DefaultClient, "/api/?key=v")
+}
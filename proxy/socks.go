@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/lqqyt2423/go-mitmproxy/internal/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+// handle socks4/4a/5 client, after handshake it's the same as a CONNECT tunnel
+func (l *sniffListener) handleSocks(cconn *wrapClientConn) {
+	e := l.entry
+	proxy := e.proxy
+	connCtx := cconn.connCtx
+
+	log := log.WithFields(log.Fields{
+		"in":   "Proxy.sniffListener.handleSocks",
+		"host": cconn.RemoteAddr().String(),
+	})
+
+	var auth func(user, password string) bool
+	if proxy.authProxy != nil {
+		auth = proxy.socksAuth
+	}
+	socksReq, err := helper.ReadSocksRequest(cconn.r, cconn, auth)
+	if err != nil {
+		cconn.Close()
+		log.Errorf("socks handshake error: %v", err)
+		return
+	}
+	connCtx.ClientConn.originalDst = socksReq.Addr
+	connCtx.authenticated = true
+	log = log.WithField("addr", socksReq.Addr)
+
+	req := newOriginalDstConnectRequest(connCtx)
+	shouldIntercept := (proxy.shouldIntercept == nil || proxy.shouldIntercept(req)) && !proxy.passthrough.Contains(req.Host)
+	f := newFlow()
+	f.Request = newRequest(req)
+	f.ConnContext = connCtx
+	f.ConnContext.Intercept = shouldIntercept
+	defer f.finish()
+
+	// trigger addon event Requestheaders
+	for _, addon := range proxy.Addons {
+		addon.Requestheaders(f)
+	}
+
+	if !shouldIntercept {
+		log.Debugf("begin transpond %v", socksReq.Addr)
+		conn, err := proxy.getUpstreamConn(req.Context(), req)
+		if err != nil {
+			for _, addon := range proxy.Addons {
+				addon.HTTPConnectError(f, err)
+			}
+			helper.WriteSocksReply(cconn, socksReq.Version, false)
+			cconn.Close()
+			return
+		}
+		defer conn.Close()
+		if err := e.establishSocks(cconn, socksReq.Version, f); err != nil {
+			return
+		}
+		defer cconn.Close()
+		transfer(log, conn, cconn)
+		return
+	}
+
+	if err := e.establishSocks(cconn, socksReq.Version, f); err != nil {
+		return
+	}
+
+	peek, err := cconn.Peek(3)
+	if err != nil {
+		cconn.Close()
+		logErr(log, err)
+		return
+	}
+
+	if helper.IsTls(peek) {
+		e.handleOriginalDstTls(cconn)
+		return
+	}
+
+	buffered, err := cconn.PeekBuffered()
+	if err == io.EOF {
+		err = nil
+	}
+	if err != nil {
+		cconn.Close()
+		log.Error(err)
+		return
+	}
+
+	if helper.IsWebSocket(buffered) {
+		conn, err := proxy.attacker.httpsDial(req.Context(), req)
+		if err != nil {
+			cconn.Close()
+			log.Error(err)
+			return
+		}
+		err = proxy.webSocketHandler.handle(conn, cconn, f)
+		if err != nil {
+			log.Errorf("WebSocket handle error: %v", err)
+			cconn.Close()
+			conn.Close()
+		}
+		return
+	}
+
+	// plain http, will go to entry.ServeHTTP
+	if helper.IsHttp(buffered) {
+		l.serve(cconn)
+		return
+	}
+
+	conn, err := proxy.attacker.httpsDial(req.Context(), req)
+	if err != nil {
+		cconn.Close()
+		log.Error(err)
+		return
+	}
+	transfer(log, conn, cconn)
+	conn.Close()
+	cconn.Close()
+}
+
+// reply socks client connection established, like entry.establishConnection
+func (e *entry) establishSocks(cconn *wrapClientConn, version byte, f *Flow) error {
+	if err := helper.WriteSocksReply(cconn, version, true); err != nil {
+		cconn.Close()
+		for _, addon := range e.proxy.Addons {
+			addon.HTTPConnectError(f, err)
+		}
+		return err
+	}
+
+	f.Response = &Response{
+		StatusCode: 200,
+		Header:     make(http.Header),
+	}
+
+	// trigger addon event Responseheaders
+	for _, addon := range e.proxy.Addons {
+		addon.Responseheaders(f)
+	}
+
+	return nil
+}
+
+// socks username/password authentication, reuse the hook of SetAuthProxy
+func (proxy *Proxy) socksAuth(user, password string) bool {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{},
+		Header: make(http.Header),
+	}
+	req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user+":"+password)))
+	ok, err := proxy.authProxy(&discardResponseWriter{header: make(http.Header)}, req)
+	if !ok {
+		log.Errorf("Proxy socks authentication failed: %v", err)
+	}
+	return ok
+}
+
+// discardResponseWriter is passed to the authProxy hook of socks clients and replayed requests, which have no client to receive http response
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header            { return w.header }
+func (w *discardResponseWriter) Write(data []byte) (int, error) { return len(data), nil }
+func (w *discardResponseWriter) WriteHeader(statusCode int)     {}
+func (w *discardResponseWriter) Flush()                         {}
@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http/httptrace"
+	"strings"
+	"time"
+)
+
+// Timing timestamps of a flow, zero if not happened.
+// Dns, Connect and TlsHandshake belong to the server connection, they are earlier than RequestHeaders if the connection is reused.
+type Timing struct {
+	ClientConnect     time.Time // client connection accepted
+	RequestHeaders    time.Time // request headers received from client
+	DnsDone           time.Time // upstream dns lookup done
+	ConnectDone       time.Time // upstream tcp connection established
+	TlsHandshakeDone  time.Time // upstream tls handshake done
+	RequestSent       time.Time // request headers and body written to upstream
+	FirstResponseByte time.Time // first byte of response headers received from upstream
+	ResponseComplete  time.Time // response body read completely from upstream
+}
+
+// MarshalJSON unix milliseconds, 0 if not happened
+func (t *Timing) MarshalJSON() ([]byte, error) {
+	ms := func(tm time.Time) float64 {
+		if tm.IsZero() {
+			return 0
+		}
+		return float64(tm.UnixMicro()) / 1000
+	}
+	m := make(map[string]interface{})
+	m["clientConnect"] = ms(t.ClientConnect)
+	m["requestHeaders"] = ms(t.RequestHeaders)
+	m["dnsDone"] = ms(t.DnsDone)
+	m["connectDone"] = ms(t.ConnectDone)
+	m["tlsHandshakeDone"] = ms(t.TlsHandshakeDone)
+	m["requestSent"] = ms(t.RequestSent)
+	m["firstResponseByte"] = ms(t.FirstResponseByte)
+	m["responseComplete"] = ms(t.ResponseComplete)
+	return json.Marshal(m)
+}
+
+// String durations of the phases after request headers received, such as: dns 2ms, connect 10ms, tls 25ms, send 0ms, wait 40ms, receive 3ms.
+// Phases of a reused server connection are omitted.
+func (t *Timing) String() string {
+	if t.RequestHeaders.IsZero() {
+		return ""
+	}
+	var phases []string
+	last := t.RequestHeaders
+	add := func(name string, tm time.Time) {
+		if tm.IsZero() || tm.Before(last) {
+			return
+		}
+		phases = append(phases, fmt.Sprintf("%v %vms", name, tm.Sub(last).Milliseconds()))
+		last = tm
+	}
+	add("dns", t.DnsDone)
+	add("connect", t.ConnectDone)
+	add("tls", t.TlsHandshakeDone)
+	add("send", t.RequestSent)
+	add("wait", t.FirstResponseByte)
+	add("receive", t.ResponseComplete)
+	return strings.Join(phases, ", ")
+}
+
+// record dns and tcp connect done time when dialing to server, net.Dialer reports them through httptrace
+func withDialTiming(ctx context.Context, serverConn *ServerConn) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			serverConn.dnsDoneTime = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err == nil {
+				serverConn.connectDoneTime = time.Now()
+			}
+		},
+	})
+}
+
+// record timing of sending request to server
+func withFlowTiming(ctx context.Context, timing *Timing) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			timing.DnsDone = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err == nil {
+				timing.ConnectDone = time.Now()
+			}
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err == nil {
+				timing.TlsHandshakeDone = time.Now()
+			}
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			timing.RequestSent = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			timing.FirstResponseByte = time.Now()
+		},
+	})
+}
+
+// use the timing of server connection, which maybe established before the flow
+func (t *Timing) fillServerConn(serverConn *ServerConn) {
+	if serverConn == nil {
+		return
+	}
+	if !serverConn.dnsDoneTime.IsZero() {
+		t.DnsDone = serverConn.dnsDoneTime
+	}
+	if !serverConn.connectDoneTime.IsZero() {
+		t.ConnectDone = serverConn.connectDoneTime
+	}
+	if !serverConn.tlsHandshakeDoneTime.IsZero() {
+		t.TlsHandshakeDone = serverConn.tlsHandshakeDoneTime
+	}
+}
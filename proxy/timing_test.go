@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+type testTimingAddon struct {
+	BaseAddon
+	timing chan Timing
+}
+
+func (a *testTimingAddon) Response(f *Flow) {
+	a.timing <- *f.Timing
+}
+
+func TestTiming(t *testing.T) {
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29126",
+	}
+	helper.init(t)
+	testProxy := helper.testProxy
+	timingAddon := &testTimingAddon{timing: make(chan Timing, 1)}
+	testProxy.AddAddon(timingAddon)
+	defer helper.ln.Close()
+	go helper.server.Serve(helper.ln)
+	defer helper.tlsPlainLn.Close()
+	go helper.server.Serve(helper.tlsLn)
+	go testProxy.Start()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	checkOrder := func(t *testing.T, names []string, times ...time.Time) {
+		t.Helper()
+		for i, tm := range times {
+			if tm.IsZero() {
+				t.Fatalf("%v should not be zero", names[i])
+			}
+			if i > 0 && tm.Before(times[i-1]) {
+				t.Fatalf("%v should not before %v", names[i], names[i-1])
+			}
+		}
+	}
+
+	t.Run("http", func(t *testing.T) {
+		testSendRequest(t, helper.httpEndpoint, helper.getProxyClient(), "ok")
+		timing := <-timingAddon.timing
+		checkOrder(t,
+			[]string{"ClientConnect", "RequestHeaders", "ConnectDone", "RequestSent", "FirstResponseByte", "ResponseComplete"},
+			timing.ClientConnect, timing.RequestHeaders, timing.ConnectDone, timing.RequestSent, timing.FirstResponseByte, timing.ResponseComplete)
+		if !timing.TlsHandshakeDone.IsZero() {
+			t.Fatal("TlsHandshakeDone should be zero")
+		}
+	})
+
+	t.Run("https", func(t *testing.T) {
+		testSendRequest(t, helper.httpsEndpoint, helper.getProxyClient(), "ok")
+		timing := <-timingAddon.timing
+		checkOrder(t,
+			[]string{"ClientConnect", "ConnectDone", "TlsHandshakeDone", "RequestHeaders", "RequestSent", "FirstResponseByte", "ResponseComplete"},
+			timing.ClientConnect, timing.ConnectDone, timing.TlsHandshakeDone, timing.RequestHeaders, timing.RequestSent, timing.FirstResponseByte, timing.ResponseComplete)
+	})
+}
+
+func TestTimingString(t *testing.T) {
+	start := time.Now()
+	timing := &Timing{
+		DnsDone:           start.Add(-time.Second), // reused connection
+		RequestHeaders:    start,
+		RequestSent:       start.Add(time.Millisecond),
+		FirstResponseByte: start.Add(time.Millisecond * 41),
+		ResponseComplete:  start.Add(time.Millisecond * 44),
+	}
+	if s := timing.String(); s != "send 1ms, wait 40ms, receive 3ms" {
+		t.Fatalf("unexpected timing: %v", s)
+	}
+}
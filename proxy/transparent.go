@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/lqqyt2423/go-mitmproxy/internal/helper"
+	log "github.com/sirupsen/logrus"
+)
+
+// read the original destination of the redirected connection
+func setOriginalDst(cconn *wrapClientConn) {
+	originalDst, err := helper.GetOriginalDst(cconn.Conn)
+	if err != nil {
+		log.Debugf("get original dst error: %v", err)
+		return
+	}
+	if originalDst == cconn.LocalAddr().String() {
+		// not redirected, connect to proxy directly
+		return
+	}
+	cconn.connCtx.ClientConn.originalDst = originalDst
+}
+
+// handle tls connection which destination is known before, in transparent mode or after socks handshake,
+// like CONNECT but without the CONNECT request
+func (e *entry) handleOriginalDstTls(cconn *wrapClientConn) {
+	proxy := e.proxy
+	connCtx := cconn.connCtx
+	connCtx.ClientConn.Tls = true
+	connCtx.Intercept = true
+
+	log := log.WithFields(log.Fields{
+		"in":   "Proxy.entry.handleOriginalDstTls",
+		"host": connCtx.ClientConn.originalDst,
+	})
+
+	if connCtx.ClientConn.originalDst == "" {
+		// SO_ORIGINAL_DST is not available, such as redirected by other tools, fallback to SNI
+		chi, err := cconn.peekClientHello()
+		if err != nil || chi.ServerName == "" {
+			cconn.Close()
+			log.Error("no original destination or SNI of tls connection")
+			return
+		}
+		connCtx.ClientConn.originalDst = net.JoinHostPort(chi.ServerName, "443")
+		log = log.WithField("host", connCtx.ClientConn.originalDst)
+	}
+
+	req := newOriginalDstConnectRequest(connCtx)
+
+	if proxy.passthrough.Contains(req.Host) || !proxy.interceptTls(cconn) {
+		connCtx.Intercept = false
+		log.Debugf("begin transpond %v", req.Host)
+		conn, err := proxy.getUpstreamConn(req.Context(), req)
+		if err != nil {
+			cconn.Close()
+			log.Error(err)
+			return
+		}
+		transfer(log, conn, cconn)
+		conn.Close()
+		cconn.Close()
+		return
+	}
+
+	if connCtx.ClientConn.UpstreamCert && !proxy.forwardsClientCert() {
+		conn, err := proxy.attacker.httpsDial(req.Context(), req)
+		if err != nil {
+			cconn.Close()
+			log.Error(err)
+			return
+		}
+		proxy.attacker.httpsTlsDial(req.Context(), cconn, conn)
+		return
+	}
+
+	proxy.attacker.httpsLazyAttack(req.Context(), cconn, req)
+}
+
+// newOriginalDstConnectRequest create a fake CONNECT request which host is the original destination,
+// so that the dial functions of attacker could be reused.
+func newOriginalDstConnectRequest(connCtx *ConnContext) *http.Request {
+	host := connCtx.ClientConn.originalDst
+	ctx := context.WithValue(context.Background(), connContextKey, connCtx)
+	req, _ := http.NewRequestWithContext(ctx, "CONNECT", "", nil)
+	req.URL = &url.URL{Host: host}
+	req.Host = host
+	return req
+}
+
+// fill the url of the plain http request received in transparent mode or after socks handshake,
+// the original destination is dialed, and the Host header is kept in req.Host
+func fillRelativeURL(req *http.Request) {
+	connCtx := req.Context().Value(connContextKey).(*ConnContext)
+	req.URL.Scheme = "http"
+	req.URL.Host = connCtx.ClientConn.originalDst
+	if req.URL.Host == "" {
+		// SO_ORIGINAL_DST is not available, fallback to the Host header
+		req.URL.Host = req.Host
+	}
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTransparentMode(t *testing.T) {
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29110",
+	}
+	helper.init(t)
+	httpEndpoint := helper.httpEndpoint
+	defer helper.ln.Close()
+	go helper.server.Serve(helper.ln)
+	defer helper.tlsPlainLn.Close()
+	go helper.server.Serve(helper.tlsLn)
+
+	testProxy, err := NewProxy(&Options{
+		Addr:        helper.proxyAddr,
+		SslInsecure: true,
+		Mode:        ModeTransparent,
+	})
+	handleError(t, err)
+	testProxy.AddAddon(&interceptAddon{})
+	dstAddon := &originalDstAddon{}
+	testProxy.AddAddon(dstAddon)
+	go testProxy.Start()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	// dial to proxy directly, as if redirected by iptables
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, "127.0.0.1"+helper.proxyAddr)
+			},
+		},
+	}
+
+	t.Run("can proxy http", func(t *testing.T) {
+		testSendRequest(t, httpEndpoint, client, "ok")
+	})
+
+	t.Run("can intercept request", func(t *testing.T) {
+		testSendRequest(t, httpEndpoint+"intercept-request", client, "intercept-request")
+	})
+
+	t.Run("can intercept response", func(t *testing.T) {
+		testSendRequest(t, httpEndpoint+"intercept-response", client, "intercept-response")
+	})
+
+	t.Run("fallback to SNI of tls without original destination", func(t *testing.T) {
+		tlsClient := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, network, "127.0.0.1"+helper.proxyAddr)
+				},
+			},
+		}
+		testSendRequest(t, "https://example.com/intercept-request", tlsClient, "intercept-request")
+		if dst := dstAddon.get(); dst != "example.com:443" {
+			t.Fatalf("expected original destination example.com:443, got %v", dst)
+		}
+	})
+}
+
+// record the original destination of the last request
+type originalDstAddon struct {
+	BaseAddon
+	mu  sync.Mutex
+	dst string
+}
+
+// example.com is not dialed before the intercepted request
+func (addon *originalDstAddon) ClientConnected(client *ClientConn) {
+	client.UpstreamCert = false
+}
+
+func (addon *originalDstAddon) Requestheaders(f *Flow) {
+	addon.mu.Lock()
+	defer addon.mu.Unlock()
+	addon.dst = f.ConnContext.ClientConn.originalDst
+}
+
+func (addon *originalDstAddon) get() string {
+	addon.mu.Lock()
+	defer addon.mu.Unlock()
+	return addon.dst
+}
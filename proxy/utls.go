@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	utls "github.com/refraction-networking/utls"
+	log "github.com/sirupsen/logrus"
+)
+
+// UpstreamHelloMimic Options.UpstreamClientHello to send the ClientHello of client to upstream
+const UpstreamHelloMimic = "mimic"
+
+// browser profiles of Options.UpstreamClientHello
+var upstreamHelloProfiles = map[string]utls.ClientHelloID{
+	"chrome":  utls.HelloChrome_Auto,
+	"firefox": utls.HelloFirefox_Auto,
+	"safari":  utls.HelloSafari_Auto,
+	"edge":    utls.HelloEdge_Auto,
+	"ios":     utls.HelloIOS_Auto,
+}
+
+func validateUpstreamHello(name string) error {
+	if name == "" || name == UpstreamHelloMimic {
+		return nil
+	}
+	if _, ok := upstreamHelloProfiles[name]; ok {
+		return nil
+	}
+	return fmt.Errorf("unsupported UpstreamClientHello %v, should be mimic, chrome, firefox, safari, edge or ios", name)
+}
+
+// upstreamHelloSpec the ClientHello sent to upstream, nil to use crypto/tls
+func (proxy *Proxy) upstreamHelloSpec(client *ClientConn) *utls.ClientHelloSpec {
+	name := proxy.Opts.UpstreamClientHello
+	if name == "" {
+		return nil
+	}
+
+	if name == UpstreamHelloMimic {
+		// plain http client in reverse mode has no ClientHello
+		if client.helloRecord == nil {
+			return nil
+		}
+		// extensions order, curves, GREASE and signature algorithms are the same as client
+		spec, err := (&utls.Fingerprinter{AllowBluntMimicry: true}).FingerprintClientHello(client.helloRecord)
+		if err != nil {
+			log.Debugf("mimic client hello error, fallback to crypto/tls: %v", err)
+			return nil
+		}
+		return spec
+	}
+
+	spec, err := utls.UTLSIdToSpec(upstreamHelloProfiles[name])
+	if err != nil {
+		log.Debugf("client hello profile %v error, fallback to crypto/tls: %v", name, err)
+		return nil
+	}
+	// offer the protocols of client, the negotiated protocol is served to client
+	protos := []string{"http/1.1"}
+	if client.clientHello != nil && len(client.clientHello.SupportedProtos) > 0 {
+		protos = client.clientHello.SupportedProtos
+	}
+	for _, ext := range spec.Extensions {
+		if alpn, ok := ext.(*utls.ALPNExtension); ok {
+			alpn.AlpnProtocols = protos
+		}
+	}
+	return &spec
+}
+
+// utlsHandshake tls handshake with upstream by the ClientHello of spec, the settings of config are converted
+func utlsHandshake(ctx context.Context, conn net.Conn, config *tls.Config, spec *utls.ClientHelloSpec) (net.Conn, *tls.ConnectionState, error) {
+	uconfig := &utls.Config{
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		KeyLogWriter:       config.KeyLogWriter,
+	}
+	if config.VerifyConnection != nil {
+		uconfig.VerifyConnection = func(cs utls.ConnectionState) error {
+			return config.VerifyConnection(fromUtlsState(cs))
+		}
+	}
+	for i := range config.Certificates {
+		uconfig.Certificates = append(uconfig.Certificates, toUtlsCert(&config.Certificates[i]))
+	}
+	if config.GetClientCertificate != nil {
+		uconfig.GetClientCertificate = func(info *utls.CertificateRequestInfo) (*utls.Certificate, error) {
+			c, err := config.GetClientCertificate(&tls.CertificateRequestInfo{
+				AcceptableCAs: info.AcceptableCAs,
+				Version:       info.Version,
+			})
+			if err != nil {
+				return nil, err
+			}
+			uc := toUtlsCert(c)
+			return &uc, nil
+		}
+	}
+
+	uconn := utls.UClient(conn, uconfig, utls.HelloCustom)
+	if err := uconn.ApplyPreset(spec); err != nil {
+		return nil, nil, err
+	}
+	uconn.SetSNI(config.ServerName)
+	if err := uconn.HandshakeContext(ctx); err != nil {
+		return nil, nil, err
+	}
+	state := fromUtlsState(uconn.ConnectionState())
+	return uconn, &state, nil
+}
+
+func toUtlsCert(c *tls.Certificate) utls.Certificate {
+	uc := utls.Certificate{
+		Certificate:                 c.Certificate,
+		PrivateKey:                  c.PrivateKey,
+		OCSPStaple:                  c.OCSPStaple,
+		SignedCertificateTimestamps: c.SignedCertificateTimestamps,
+		Leaf:                        c.Leaf,
+	}
+	for _, s := range c.SupportedSignatureAlgorithms {
+		uc.SupportedSignatureAlgorithms = append(uc.SupportedSignatureAlgorithms, utls.SignatureScheme(s))
+	}
+	return uc
+}
+
+func fromUtlsState(cs utls.ConnectionState) tls.ConnectionState {
+	return tls.ConnectionState{
+		Version:                     cs.Version,
+		HandshakeComplete:           cs.HandshakeComplete,
+		DidResume:                   cs.DidResume,
+		CipherSuite:                 cs.CipherSuite,
+		NegotiatedProtocol:          cs.NegotiatedProtocol,
+		ServerName:                  cs.ServerName,
+		PeerCertificates:            cs.PeerCertificates,
+		VerifiedChains:              cs.VerifiedChains,
+		SignedCertificateTimestamps: cs.SignedCertificateTimestamps,
+		OCSPResponse:                cs.OCSPResponse,
+	}
+}
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/internal/helper"
+)
+
+// helloListener record the ClientHello received by server
+type helloListener struct {
+	net.Listener
+}
+
+func (l *helloListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &helloConn{Conn: c}, nil
+}
+
+func TestUpstreamClientHello(t *testing.T) {
+	hellos := make(chan []byte, 10)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	server.Listener = &helloListener{server.Listener}
+	server.EnableHTTP2 = true
+	server.TLS = &tls.Config{
+		GetConfigForClient: func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
+			hellos <- chi.Conn.(*helloConn).record()
+			return nil, nil
+		},
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	for name, addr := range map[string]string{UpstreamHelloMimic: "127.0.0.1:29136", "chrome": "127.0.0.1:29137"} {
+		t.Run(name, func(t *testing.T) {
+			testProxy, err := NewProxy(&Options{
+				Addr:                addr,
+				SslInsecure:         true,
+				UpstreamClientHello: name,
+			})
+			handleError(t, err)
+			addon := &fingerprintAddon{connCtxs: make(chan *ConnContext, 10)}
+			testProxy.AddAddon(addon)
+			go testProxy.Start()
+			defer testProxy.Close()
+			time.Sleep(time.Millisecond * 50) // wait for test proxy startup
+
+			client := &http.Client{
+				Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{
+						InsecureSkipVerify: true,
+					},
+					ForceAttemptHTTP2: true,
+					Proxy: func(r *http.Request) (*url.URL, error) {
+						return url.Parse("http://" + addr)
+					},
+				},
+			}
+			testSendRequest(t, server.URL, client, "ok")
+
+			var connCtx *ConnContext
+			for connCtx = range addon.connCtxs {
+				if connCtx.ClientConn.Tls {
+					break
+				}
+			}
+			if _, ok := connCtx.ServerConn.tlsConn.(*tls.Conn); ok {
+				t.Fatal("expected handshake with upstream by utls")
+			}
+			if connCtx.ServerConn.tlsState.NegotiatedProtocol != "h2" {
+				t.Fatalf("expected h2 with upstream, but got %q", connCtx.ServerConn.tlsState.NegotiatedProtocol)
+			}
+			ja3, _, err := helper.ClientHelloFingerprint(<-hellos)
+			handleError(t, err)
+			if mimic := name == UpstreamHelloMimic; mimic != (ja3 == connCtx.ClientConn.JA3) {
+				t.Fatalf("unexpected upstream fingerprint %v, client %v", ja3, connCtx.ClientConn.JA3)
+			}
+		})
+	}
+
+	if _, err := NewProxy(&Options{UpstreamClientHello: "netscape"}); err == nil {
+		t.Fatal("expected unsupported UpstreamClientHello error")
+	}
+}
@@ -0,0 +1,217 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/lqqyt2423/go-mitmproxy/internal/helper"
+)
+
+// UpstreamVerifyRule verification policy of the upstream servers of Hosts, override Options.SslInsecure
+type UpstreamVerifyRule struct {
+	Hosts    []string // host patterns matched by helper.MatchHost, such as *.example.com, example.com:8443
+	Insecure bool     // skip the chain and hostname verification
+	Pins     []string // sha256/<base64 of sha256 of SubjectPublicKeyInfo>, one certificate of the chain must match
+}
+
+// LoadUpstreamVerifyRules read verification rules from json or yaml config file:
+//
+//	{"Items": [{"Hosts": ["*.internal.example.com"], "Insecure": true}, {"Hosts": ["api.example.com"], "Pins": ["sha256/..."]}]}
+func LoadUpstreamVerifyRules(filename string) ([]*UpstreamVerifyRule, error) {
+	var config struct {
+		Items []*UpstreamVerifyRule
+	}
+	if err := helper.NewStructFromFile(filename, &config); err != nil {
+		return nil, err
+	}
+	for i, r := range config.Items {
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("%v %w", i, err)
+		}
+	}
+	return config.Items, nil
+}
+
+func (r *UpstreamVerifyRule) validate() error {
+	if len(r.Hosts) == 0 {
+		return errors.New("empty Hosts")
+	}
+	for _, pin := range r.Pins {
+		b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/"))
+		if !strings.HasPrefix(pin, "sha256/") || err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("invalid pin %v", pin)
+		}
+	}
+	return nil
+}
+
+// LoadUpstreamRootCAs the system root CAs with the extra root CAs of PEM files
+func LoadUpstreamRootCAs(filenames ...string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	for _, filename := range filenames {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in %v", filename)
+		}
+	}
+	return pool, nil
+}
+
+// CertPin the pin of certificate: sha256/<base64 of sha256 of SubjectPublicKeyInfo>
+func CertPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// reasons of CertVerifyError
+const (
+	CertVerifyUnknownAuthority = "unknown_authority"
+	CertVerifyHostname         = "hostname_mismatch"
+	CertVerifyExpired          = "expired"
+	CertVerifyPinMismatch      = "pin_mismatch"
+	CertVerifyInvalid          = "invalid"
+)
+
+// CertVerifyError upstream certificate is rejected, passed to RequestError and HTTPConnectError
+type CertVerifyError struct {
+	Host   string              // ServerName of the handshake
+	Reason string              // CertVerifyUnknownAuthority, CertVerifyHostname ...
+	Chain  []*x509.Certificate // certificates sent by upstream, leaf first
+	Err    error
+}
+
+func (e *CertVerifyError) Error() string {
+	return fmt.Sprintf("upstream certificate of %v rejected (%v): %v", e.Host, e.Reason, e.Err)
+}
+
+func (e *CertVerifyError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CertVerifyError) MarshalJSON() ([]byte, error) {
+	chain := make([]map[string]interface{}, 0, len(e.Chain))
+	for _, c := range e.Chain {
+		sum := sha256.Sum256(c.Raw)
+		chain = append(chain, map[string]interface{}{
+			"subject":     c.Subject.String(),
+			"issuer":      c.Issuer.String(),
+			"dnsNames":    c.DNSNames,
+			"notBefore":   c.NotBefore,
+			"notAfter":    c.NotAfter,
+			"fingerprint": hex.EncodeToString(sum[:]),
+			"pin":         CertPin(c),
+			"pem":         string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})),
+		})
+	}
+	return json.Marshal(map[string]interface{}{
+		"host":   e.Host,
+		"reason": e.Reason,
+		"error":  e.Err.Error(),
+		"chain":  chain,
+	})
+}
+
+func certVerifyReason(err error) string {
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &unknownAuthorityErr):
+		return CertVerifyUnknownAuthority
+	case errors.As(err, &hostnameErr):
+		return CertVerifyHostname
+	case errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired:
+		return CertVerifyExpired
+	}
+	return CertVerifyInvalid
+}
+
+// upstreamMatchAddress the address matched by the Hosts of upstream rules: ServerName of the tls handshake
+// with the port of the destination address, the same for all transports. The address if no ServerName.
+func upstreamMatchAddress(serverName, address string) string {
+	if serverName == "" {
+		return address
+	}
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return serverName
+	}
+	return net.JoinHostPort(serverName, port)
+}
+
+// the first verification rule matched the address, nil if not matched
+func (proxy *Proxy) upstreamVerifyRule(address string) *UpstreamVerifyRule {
+	for _, r := range proxy.Opts.UpstreamVerifyRules {
+		if helper.MatchHost(address, r.Hosts) {
+			return r
+		}
+	}
+	return nil
+}
+
+// setUpstreamVerify verify the upstream certificate by VerifyConnection instead of crypto/tls,
+// to apply the rule and return CertVerifyError
+func (proxy *Proxy) setUpstreamVerify(config *tls.Config, rule *UpstreamVerifyRule) {
+	config.InsecureSkipVerify = true
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		return proxy.verifyUpstream(cs, rule)
+	}
+}
+
+func (proxy *Proxy) verifyUpstream(cs tls.ConnectionState, rule *UpstreamVerifyRule) error {
+	insecure := proxy.Opts.SslInsecure
+	var pins []string
+	if rule != nil {
+		insecure = rule.Insecure
+		pins = rule.Pins
+	}
+
+	certs := cs.PeerCertificates
+	if len(certs) == 0 {
+		return &CertVerifyError{Host: cs.ServerName, Reason: CertVerifyInvalid, Err: errors.New("no certificate")}
+	}
+
+	if !insecure {
+		intermediates := x509.NewCertPool()
+		for _, c := range certs[1:] {
+			intermediates.AddCert(c)
+		}
+		_, err := certs[0].Verify(x509.VerifyOptions{
+			Roots:         proxy.Opts.UpstreamRootCAs, // nil to use the system roots
+			DNSName:       cs.ServerName,
+			Intermediates: intermediates,
+		})
+		if err != nil {
+			return &CertVerifyError{Host: cs.ServerName, Reason: certVerifyReason(err), Chain: certs, Err: err}
+		}
+	}
+
+	if len(pins) == 0 {
+		return nil
+	}
+	for _, c := range certs {
+		pin := CertPin(c)
+		for _, p := range pins {
+			if p == pin {
+				return nil
+			}
+		}
+	}
+	return &CertVerifyError{Host: cs.ServerName, Reason: CertVerifyPinMismatch, Chain: certs, Err: errors.New("no certificate matched the pins")}
+}
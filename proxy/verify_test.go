@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/cert"
+)
+
+type verifyErrorAddon struct {
+	BaseAddon
+	errs chan error
+}
+
+func (addon *verifyErrorAddon) RequestError(f *Flow, err error) {
+	addon.errs <- err
+}
+
+func (addon *verifyErrorAddon) HTTPConnectError(f *Flow, err error) {
+	addon.errs <- err
+}
+
+func TestUpstreamVerify(t *testing.T) {
+	// https server signed by the internal ca
+	ca, err := cert.NewSelfSignCAMemory()
+	handleError(t, err)
+	serverCert, err := ca.GetCert("localhost")
+	handleError(t, err)
+	leaf, err := x509.ParseCertificate(serverCert.Certificate[0])
+	handleError(t, err)
+	rootFile := filepath.Join(t.TempDir(), "root.pem")
+	handleError(t, os.WriteFile(rootFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.GetRootCA().Raw}), 0644))
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{*serverCert}})
+	handleError(t, err)
+	defer ln.Close()
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	endpoint := "https://localhost:" + strconv.Itoa(ln.Addr().(*net.TCPAddr).Port) + "/"
+
+	opts := &Options{
+		Addr: ":29132",
+	}
+	testProxy, err := NewProxy(opts)
+	handleError(t, err)
+	addon := &verifyErrorAddon{errs: make(chan error, 10)}
+	testProxy.AddAddon(addon)
+	go testProxy.Start()
+	defer testProxy.Close()
+	time.Sleep(time.Millisecond * 50) // wait for test proxy startup
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+			Proxy: func(r *http.Request) (*url.URL, error) {
+				return url.Parse("http://127.0.0.1:29132")
+			},
+			DisableKeepAlives: true,
+		},
+	}
+
+	expectRejected := func(t *testing.T, reason string) {
+		t.Helper()
+		if _, err := client.Get(endpoint); err == nil {
+			t.Fatal("expected error, but got nil")
+		}
+		select {
+		case err := <-addon.errs:
+			var certErr *CertVerifyError
+			if !errors.As(err, &certErr) {
+				t.Fatalf("expected CertVerifyError, but got %v", err)
+			}
+			if certErr.Reason != reason || certErr.Host != "localhost" || len(certErr.Chain) == 0 {
+				t.Fatalf("unexpected %+v", certErr)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected HTTPConnectError")
+		}
+	}
+
+	t.Run("unknown authority", func(t *testing.T) {
+		expectRejected(t, CertVerifyUnknownAuthority)
+	})
+
+	t.Run("root cas", func(t *testing.T) {
+		opts.UpstreamRootCAs, err = LoadUpstreamRootCAs(rootFile)
+		handleError(t, err)
+		testSendRequest(t, endpoint, client, "ok")
+	})
+
+	t.Run("pin mismatch", func(t *testing.T) {
+		other, err := cert.NewSelfSignCAMemory()
+		handleError(t, err)
+		opts.UpstreamVerifyRules = []*UpstreamVerifyRule{{Hosts: []string{"localhost"}, Pins: []string{CertPin(other.GetRootCA())}}}
+		expectRejected(t, CertVerifyPinMismatch)
+	})
+
+	t.Run("insecure host with pin", func(t *testing.T) {
+		opts.UpstreamRootCAs = nil
+		opts.UpstreamVerifyRules = []*UpstreamVerifyRule{{Hosts: []string{"*.example.com"}}, {Hosts: []string{"localhost"}, Insecure: true, Pins: []string{CertPin(leaf)}}}
+		testSendRequest(t, endpoint, client, "ok")
+
+		u, err := url.Parse(endpoint)
+		handleError(t, err)
+		f, err := testProxy.Replay(&Request{Method: "GET", URL: u, Header: http.Header{}})
+		handleError(t, err)
+		if f.Response == nil || string(f.Response.Body) != "ok" {
+			t.Fatalf("expected ok, but got %+v", f.Response)
+		}
+	})
+
+	t.Run("rule matched by sni and port of connect to ip", func(t *testing.T) {
+		port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+		opts.UpstreamVerifyRules = []*UpstreamVerifyRule{{Hosts: []string{"localhost:" + port}, Insecure: true}}
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					ServerName:         "localhost",
+					InsecureSkipVerify: true,
+				},
+				Proxy: func(r *http.Request) (*url.URL, error) {
+					return url.Parse("http://127.0.0.1:29132")
+				},
+				DisableKeepAlives: true,
+			},
+		}
+		testSendRequest(t, "https://127.0.0.1:"+port+"/", client, "ok")
+	})
+}
+
+func TestLoadUpstreamVerifyRules(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "verify.yaml")
+	handleError(t, os.WriteFile(filename, []byte("Items:\n  - Hosts: [localhost]\n    Pins: [\"sha256/invalid\"]\n"), 0644))
+	if _, err := LoadUpstreamVerifyRules(filename); err == nil {
+		t.Fatal("expected invalid pin error")
+	}
+
+	handleError(t, os.WriteFile(filename, []byte("Items:\n  - Hosts: [localhost]\n    Insecure: true\n"), 0644))
+	rules, err := LoadUpstreamVerifyRules(filename)
+	handleError(t, err)
+	if len(rules) != 1 || !rules[0].Insecure {
+		t.Fatalf("unexpected rules %+v", rules)
+	}
+}
@@ -3,12 +3,18 @@ package proxy
 import (
 	"bufio"
 	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/lqqyt2423/go-mitmproxy/internal/helper"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -19,6 +25,7 @@ var wsHandshakeHeaders = map[string]struct{}{
 	"Sec-Websocket-Version":    {},
 	"Sec-Websocket-Extensions": {},
 	"Sec-Websocket-Protocol":   {},
+	"Sec-Websocket-Accept":     {},
 }
 
 func cloneHeaderWithoutWSHandshake(h http.Header) http.Header {
@@ -41,6 +48,26 @@ type webSocketHandler struct {
 	proxy *Proxy
 }
 
+const (
+	wsControlWriteWait = time.Second * 5 // 控制帧写入超时
+	wsCloseWait        = time.Second * 5 // 一方发送 close 帧后，等待另一方回应 close 帧
+)
+
+// wsConn serialize the writes of forwarding and Flow.WebSocketInject, websocket.Conn supports one concurrent writer
+type wsConn struct {
+	*websocket.Conn
+	mu sync.Mutex
+}
+
+func (c *wsConn) writeMessage(msgType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if msgType == websocket.CloseMessage || msgType == websocket.PingMessage || msgType == websocket.PongMessage {
+		return c.WriteControl(msgType, data, time.Now().Add(wsControlWriteWait))
+	}
+	return c.WriteMessage(msgType, data)
+}
+
 func newWebSocketHandler(proxy *Proxy) *webSocketHandler {
 	return &webSocketHandler{proxy: proxy}
 }
@@ -72,9 +99,17 @@ func (w *connResponseWriter) Write(data []byte) (int, error) {
 	return w.conn.Write(data)
 }
 
+// WriteHeader 写入状态行和响应头，连接在响应后关闭
 func (w *connResponseWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
 	w.wroteHeader = true
 	w.statusCode = statusCode
+	w.header.Set("Connection", "close")
+	fmt.Fprintf(w.conn, "HTTP/1.1 %d %s\r\n", statusCode, http.StatusText(statusCode))
+	w.header.Write(w.conn)
+	io.WriteString(w.conn, "\r\n")
 }
 
 // Hijack 劫持连接，返回底层的 net.Conn 和 bufio.ReadWriter
@@ -87,7 +122,8 @@ func (w *connResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 // handle 处理 WebSocket 连接
 // serverConn: 与服务器的连接（已经建立 TCP 连接）
 // clientConn: 与客户端的连接（已经完成 CONNECT，客户端发送了 WebSocket 握手请求）
-func (h *webSocketHandler) handle(serverConn, clientConn net.Conn, f *Flow) error {
+// connectFlow: CONNECT 请求的 flow，WebSocket 握手使用新的 flow
+func (h *webSocketHandler) handle(serverConn, clientConn net.Conn, connectFlow *Flow) error {
 	// 步骤 1: 读取客户端握手请求
 	buf := bufio.NewReader(clientConn)
 	clientReq, err := http.ReadRequest(buf)
@@ -98,6 +134,28 @@ func (h *webSocketHandler) handle(serverConn, clientConn net.Conn, f *Flow) erro
 
 	log.Debugf("Client WebSocket handshake: %s %s", clientReq.Method, clientReq.URL.Path)
 
+	serverURL := "ws://" + clientReq.Host + clientReq.URL.RequestURI()
+	if parsedURL, err := url.Parse(serverURL); err == nil {
+		clientReq.URL = parsedURL
+	}
+	respWriter := newConnResponseWriter(clientConn)
+
+	f := newFlow()
+	f.Request = newRequest(clientReq)
+	f.ConnContext = connectFlow.ConnContext
+	f.ConnContext.FlowCount.Add(1)
+	defer f.finish()
+
+	for _, addon := range h.proxy.Addons {
+		addon.Requestheaders(f)
+		if f.Response != nil {
+			writeResponse(respWriter, f.Response)
+			clientConn.Close()
+			serverConn.Close()
+			return nil
+		}
+	}
+
 	// 步骤 2: 使用 Dialer 连接到服务器
 	dialer := &websocket.Dialer{
 		NetDial: func(network, addr string) (net.Conn, error) {
@@ -107,14 +165,17 @@ func (h *webSocketHandler) handle(serverConn, clientConn net.Conn, f *Flow) erro
 		HandshakeTimeout: 0,
 	}
 
-	serverURL := "ws://" + clientReq.Host + clientReq.URL.RequestURI()
 	log.Debugf("Connecting to server: %s", serverURL)
-
-	// Dialer 会自动添加所有必需的 WebSocket 握手头
-	// 我们不传递 clientReq.Header，避免重复头的错误
-	serverWS, _, err := dialer.Dial(serverURL, nil)
+	serverWS, err := h.dialServer(dialer, serverURL, f)
 	if err != nil {
 		log.Errorf("Failed to dial server: %v", err)
+		if f.Response != nil {
+			// 服务器拒绝握手，将响应返回给客户端
+			writeResponse(respWriter, f.Response)
+			clientConn.Close()
+			serverConn.Close()
+			return nil
+		}
 		return err
 	}
 	defer serverWS.Close()
@@ -122,17 +183,7 @@ func (h *webSocketHandler) handle(serverConn, clientConn net.Conn, f *Flow) erro
 	log.Debugf("Server WebSocket connected, subprotocol: %s", serverWS.Subprotocol())
 
 	// 步骤 3: 使用 Upgrader 升级客户端连接
-	respWriter := newConnResponseWriter(clientConn)
-
-	upgrader := &websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
-		CheckOrigin: func(r *http.Request) bool {
-			return true // 代理模式下总是允许
-		},
-	}
-
-	clientWS, err := upgrader.Upgrade(respWriter, clientReq, nil)
+	clientWS, err := h.upgradeClient(respWriter, clientReq, serverWS, f)
 	if err != nil {
 		log.Errorf("Failed to upgrade client connection: %v", err)
 		return err
@@ -142,6 +193,8 @@ func (h *webSocketHandler) handle(serverConn, clientConn net.Conn, f *Flow) erro
 	log.Debugf("Client WebSocket upgraded successfully")
 
 	wsData := newWebSocketData()
+	wsData.client = &wsConn{Conn: clientWS}
+	wsData.server = &wsConn{Conn: serverWS}
 	f.WebScoket = wsData
 
 	for _, addon := range h.proxy.Addons {
@@ -149,100 +202,234 @@ func (h *webSocketHandler) handle(serverConn, clientConn net.Conn, f *Flow) erro
 	}
 
 	// 步骤 4: 双向转发消息
-	return h.forwardMessages(clientWS, serverWS, f)
+	return h.forwardMessages(f)
+}
+
+// dialServer 与服务器握手，转发客户端的握手请求头、子协议和 permessage-deflate 扩展
+// 服务器的握手响应保存为 f.Response，并触发 Responseheaders
+func (h *webSocketHandler) dialServer(dialer *websocket.Dialer, serverURL string, f *Flow) (*websocket.Conn, error) {
+	// Dialer 会自动添加所有必需的 WebSocket 握手头，其余请求头原样转发
+	dialer.Subprotocols = websocket.Subprotocols(&http.Request{Header: f.Request.Header})
+	dialer.EnableCompression = hasPerMessageDeflate(f.Request.Header)
+	serverWS, resp, err := dialer.Dial(serverURL, cloneHeaderWithoutWSHandshake(f.Request.Header))
+	if resp == nil {
+		return nil, err
+	}
+
+	f.Response = &Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+	}
+	if err != nil {
+		// ErrBadHandshake 时 resp.Body 为服务器响应的前 1024 字节
+		f.Response.Body, _ = io.ReadAll(resp.Body)
+		f.Response.Header.Del("Content-Length")
+	}
+	for _, addon := range h.proxy.Addons {
+		addon.Responseheaders(f)
+	}
+	return serverWS, err
+}
+
+// upgradeClient 升级客户端连接，返回服务器选择的子协议和 f.Response 中的响应头
+func (h *webSocketHandler) upgradeClient(res http.ResponseWriter, req *http.Request, serverWS *websocket.Conn, f *Flow) (*websocket.Conn, error) {
+	upgrader := &websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin: func(r *http.Request) bool {
+			return true // 代理模式下总是允许
+		},
+		EnableCompression: hasPerMessageDeflate(f.Response.Header),
+	}
+	header := cloneHeaderWithoutWSHandshake(f.Response.Header)
+	if subprotocol := serverWS.Subprotocol(); subprotocol != "" {
+		header.Set("Sec-Websocket-Protocol", subprotocol)
+	}
+	return upgrader.Upgrade(res, req, header)
+}
+
+func hasPerMessageDeflate(header http.Header) bool {
+	for _, v := range header.Values("Sec-Websocket-Extensions") {
+		if strings.Contains(strings.ToLower(v), "permessage-deflate") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeResponse 向客户端返回 addon 设置的响应或服务器拒绝握手的响应
+func writeResponse(res http.ResponseWriter, response *Response) {
+	for key, vals := range response.Header {
+		for _, v := range vals {
+			res.Header().Add(key, v)
+		}
+	}
+	res.WriteHeader(response.StatusCode)
+	if len(response.Body) > 0 {
+		_, _ = res.Write(response.Body)
+	}
 }
 
-// forwardMessages 双向转发 WebSocket 消息
-func (h *webSocketHandler) forwardMessages(clientWS, serverWS *websocket.Conn, f *Flow) error {
+// forwardMessages 双向转发 WebSocket 消息，ping、pong 和 close 控制帧同样记录并转发
+func (h *webSocketHandler) forwardMessages(f *Flow) error {
 	defer func() {
 		for _, addon := range h.proxy.Addons {
 			addon.WebSocketEnd(f)
 		}
 	}()
 
+	wsData := f.WebScoket
 	errChan := make(chan error, 2)
 
-	// 客户端 -> 服务器
-	go func() {
-		defer func() {
-			// 优雅关闭服务器连接
-			serverWS.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
-			serverWS.Close()
-		}()
-
+	forward := func(src *wsConn, fromClient bool) {
+		h.setControlHandlers(f, src, fromClient)
 		for {
-			msgType, msg, err := clientWS.ReadMessage()
+			msgType, msg, err := src.ReadMessage()
 			if err != nil {
-				// 判断是否是正常的关闭
-				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
-					errChan <- nil // 正常关闭，不返回错误
+				var closeErr *websocket.CloseError
+				if errors.As(err, &closeErr) {
+					errChan <- nil // close 帧已经在 close handler 中转发
 					return
 				}
+				// 没有 close 帧而断开
+				wsData.setClosed(websocket.CloseAbnormalClosure, err.Error(), fromClient)
 				errChan <- err
 				return
 			}
 
-			f.WebScoket.addMessage(msgType, msg, true)
-			for _, addon := range h.proxy.Addons {
-				addon.WebSocketMessage(f)
-			}
-
-			if err := serverWS.WriteMessage(msgType, msg); err != nil {
-				log.Errorf("Client -> Server: Write error: %v", err)
+			if err := h.sendMessage(f, newWebSocketMessage(msgType, msg, fromClient)); err != nil {
+				direction := "Server -> Client"
+				if fromClient {
+					direction = "Client -> Server"
+				}
+				log.Errorf("%v: Write error: %v", direction, err)
+				wsData.setClosed(websocket.CloseAbnormalClosure, err.Error(), !fromClient)
 				errChan <- err
 				return
 			}
 		}
-	}()
-
+	}
+	// 客户端 -> 服务器
+	go forward(wsData.client, true)
 	// 服务器 -> 客户端
-	go func() {
-		defer func() {
-			// 优雅关闭客户端连接
-			clientWS.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
-			clientWS.Close()
-		}()
+	go forward(wsData.server, false)
 
-		for {
-			msgType, msg, err := serverWS.ReadMessage()
-			if err != nil {
-				// 判断是否是正常的关闭
-				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
-					errChan <- nil // 正常关闭，不返回错误
-					return
-				}
-				errChan <- err
-				return
-			}
+	// 一方正常关闭时，等待另一方回应的 close 帧转发完成
+	err := <-errChan
+	if err == nil {
+		select {
+		case <-errChan:
+		case <-time.After(wsCloseWait):
+		}
+	}
+	wsData.client.Close()
+	wsData.server.Close()
+	return err
+}
 
-			f.WebScoket.addMessage(msgType, msg, false)
-			for _, addon := range h.proxy.Addons {
-				addon.WebSocketMessage(f)
-			}
+// setControlHandlers 记录并转发 src 收到的控制帧，替代默认的自动回复，由另一方回复
+func (h *webSocketHandler) setControlHandlers(f *Flow, src *wsConn, fromClient bool) {
+	wsData := f.WebScoket
+	src.SetPingHandler(func(data string) error {
+		return h.sendMessage(f, newWebSocketMessage(websocket.PingMessage, []byte(data), fromClient))
+	})
+	src.SetPongHandler(func(data string) error {
+		return h.sendMessage(f, newWebSocketMessage(websocket.PongMessage, []byte(data), fromClient))
+	})
+	src.SetCloseHandler(func(code int, text string) error {
+		wsData.setClosed(code, text, fromClient)
+		return h.sendMessage(f, newWebSocketMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), fromClient))
+	})
+}
 
-			if err := clientWS.WriteMessage(msgType, msg); err != nil {
-				log.Errorf("Server -> Client: Write error: %v", err)
-				errChan <- err
-				return
-			}
+// sendMessage 记录消息并调用 addon 后发送，addon 可以修改 msg 的 Type 和 Content，或设置 Dropped 不发送
+func (h *webSocketHandler) sendMessage(f *Flow, msg *WebSocketMessage) error {
+	f.WebScoket.hookMu.Lock()
+	return h.writeMessages(f, h.callHooks(f, msg))
+}
+
+// callHooks 在持有 hookMu 时记录 msg 并调用 addon，两个方向的消息依次处理，addon 中看到的最后一条消息即为 msg
+// 期间注入的消息进入 pending，同样在此处理，最后释放 hookMu，返回按顺序待发送的消息
+func (h *webSocketHandler) callHooks(f *Flow, msg *WebSocketMessage) []*WebSocketMessage {
+	wsData := f.WebScoket
+	var msgs []*WebSocketMessage
+	for {
+		wsData.addMessage(msg)
+		for _, addon := range h.proxy.Addons {
+			addon.WebSocketMessage(f)
 		}
-	}()
+		msgs = append(msgs, msg)
 
-	// 等待任一方向出错或关闭
-	err := <-errChan
-	// 如果是正常关闭（nil），返回 nil
+		wsData.mu.Lock()
+		if len(wsData.pending) == 0 {
+			wsData.hookMu.Unlock()
+			wsData.mu.Unlock()
+			return msgs
+		}
+		msg = wsData.pending[0]
+		wsData.pending = wsData.pending[1:]
+		wsData.mu.Unlock()
+	}
+}
+
+// writeMessages 发送 addon 处理后的消息，返回第一条消息的发送错误，其后为注入的消息
+func (h *webSocketHandler) writeMessages(f *Flow, msgs []*WebSocketMessage) error {
+	var err error
+	for i, msg := range msgs {
+		if msg.Dropped {
+			continue
+		}
+		dst := f.WebScoket.client
+		if msg.FromClient {
+			dst = f.WebScoket.server
+		}
+		if e := dst.writeMessage(msg.Type, msg.Content); e != nil {
+			if i == 0 {
+				err = e
+			} else {
+				log.Errorf("send injected websocket message error: %v", e)
+			}
+		}
+	}
 	return err
 }
 
-func (h *webSocketHandler) handleWSS(res http.ResponseWriter, req *http.Request) error {
+// WebSocketInject send a synthetic message to server if fromClient, otherwise to client.
+// The message is recorded with Injected and passed to Addon.WebSocketMessage like the forwarded messages.
+// If called while the hooks of the flow are running, such as in Addon.WebSocketMessage, the message is queued
+// and sent after the current message, and the returned error is nil.
+func (f *Flow) WebSocketInject(fromClient bool, msgType int, data []byte) error {
+	wsData := f.WebScoket
+	if wsData == nil || wsData.client == nil {
+		return errors.New("websocket not established")
+	}
+	msg := newWebSocketMessage(msgType, data, fromClient)
+	msg.Injected = true
+
+	wsData.mu.Lock()
+	if !wsData.hookMu.TryLock() {
+		wsData.pending = append(wsData.pending, msg)
+		wsData.mu.Unlock()
+		return nil
+	}
+	wsData.mu.Unlock()
+	h := f.ConnContext.proxy.webSocketHandler
+	return h.writeMessages(f, h.callHooks(f, msg))
+}
+
+// handleWSS f 为握手请求的 flow，已经触发 Requestheaders
+func (h *webSocketHandler) handleWSS(res http.ResponseWriter, req *http.Request, f *Flow) error {
+	defer f.finish()
+
 	// 修复 WebSocket URL，确保包含完整的 scheme 和 host
 	serverURL := "wss://" + req.Host + req.URL.RequestURI()
 	log.Debugf("Connecting to WSS server: %s", serverURL)
 	if parsedURL, err := url.Parse(serverURL); err == nil {
 		req.URL = parsedURL
+		f.Request.URL = parsedURL
 	}
 
-	connCtx := req.Context().Value(connContextKey).(*ConnContext)
+	connCtx := f.ConnContext
 
 	// 步骤 1: 获取上游连接
 	plainConn, err := h.proxy.getUpstreamConn(req.Context(), req)
@@ -260,6 +447,8 @@ func (h *webSocketHandler) handleWSS(res http.ResponseWriter, req *http.Request)
 		connCtx: connCtx,
 	}
 	connCtx.ServerConn = serverConn
+	// 握手失败时 Dialer 会关闭服务器连接，此时客户端连接需保留到响应写完
+	connCtx.closeAfterResponse = true
 
 	// 步骤 3: 调用 addon 的 ServerConnected 回调
 	for _, addon := range connCtx.proxy.Addons {
@@ -267,32 +456,30 @@ func (h *webSocketHandler) handleWSS(res http.ResponseWriter, req *http.Request)
 	}
 
 	// 步骤 4: 创建 Dialer，使用自定义 NetDial
+	tlsConfig := &tls.Config{}
+	// Dialer 使用 URL 的 hostname 作为 ServerName
+	h.proxy.setUpstreamVerify(tlsConfig, h.proxy.upstreamVerifyRule(helper.CanonicalAddr(req.URL)))
 	dialer := &websocket.Dialer{
 		NetDial: func(network, addr string) (net.Conn, error) {
 			return serverConn.Conn, nil
 		},
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: h.proxy.Opts.SslInsecure,
-		},
+		TLSClientConfig: tlsConfig,
 	}
 
-	upstreamHeader := cloneHeaderWithoutWSHandshake(req.Header)
-	serverWS, _, err := dialer.Dial(serverURL, upstreamHeader)
+	serverWS, err := h.dialServer(dialer, serverURL, f)
 	if err != nil {
 		log.Errorf("Failed to dial WSS server: %v", err)
+		if f.Response != nil {
+			// 服务器拒绝握手，将响应返回给客户端
+			res.Header().Set("Connection", "close")
+			writeResponse(res, f.Response)
+			return nil
+		}
 		return err
 	}
 	defer serverWS.Close()
 
-	upgrader := &websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
-		CheckOrigin: func(r *http.Request) bool {
-			return true // 代理模式下总是允许
-		},
-	}
-
-	clientWS, err := upgrader.Upgrade(res, req, nil)
+	clientWS, err := h.upgradeClient(res, req, serverWS, f)
 	if err != nil {
 		log.Errorf("Failed to upgrade client connection: %v", err)
 		return err
@@ -302,16 +489,14 @@ func (h *webSocketHandler) handleWSS(res http.ResponseWriter, req *http.Request)
 	log.Debugf("Client WSS upgraded successfully")
 
 	wsData := newWebSocketData()
-	f := newFlow()
-	f.Request = newRequest(req)
-	f.ConnContext = connCtx
+	wsData.client = &wsConn{Conn: clientWS}
+	wsData.server = &wsConn{Conn: serverWS}
 	f.WebScoket = wsData
-	defer f.finish()
 
 	for _, addon := range h.proxy.Addons {
 		addon.WebSocketStart(f)
 	}
 
 	// 双向转发消息
-	return h.forwardMessages(clientWS, serverWS, f)
+	return h.forwardMessages(f)
 }
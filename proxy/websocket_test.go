@@ -506,3 +506,374 @@ func TestWebSocketUpstreamProxy(t *testing.T) {
 		time.Sleep(time.Millisecond * 100)
 	})
 }
+
+// testWebSocketModifyAddon 修改、丢弃和注入 WebSocket 消息
+type testWebSocketModifyAddon struct {
+	BaseAddon
+}
+
+func (addon *testWebSocketModifyAddon) WebSocketStart(f *Flow) {
+	if err := f.WebSocketInject(false, websocket.TextMessage, []byte("welcome")); err != nil {
+		log.Error(err)
+	}
+}
+
+func (addon *testWebSocketModifyAddon) WebSocketMessage(f *Flow) {
+	msg := f.WebScoket.Messages[len(f.WebScoket.Messages)-1]
+	if !msg.FromClient || msg.Injected {
+		return
+	}
+	switch string(msg.Content) {
+	case "drop":
+		msg.Dropped = true
+	case "inject":
+		msg.Dropped = true
+		if err := f.WebSocketInject(true, websocket.TextMessage, []byte("injected")); err != nil {
+			log.Error(err)
+		}
+	default:
+		msg.Content = append([]byte("modified "), msg.Content...)
+	}
+}
+
+// TestWebSocketModifyMessages 测试 addon 修改、丢弃和注入消息
+func TestWebSocketModifyMessages(t *testing.T) {
+	wsServer := testWebSocketServer(t, testEchoWebSocketHandler(t))
+	defer wsServer.Close()
+	wsURL, err := url.Parse(wsServer.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse WS server URL: %v", err)
+	}
+
+	proxy, err := NewProxy(&Options{
+		Addr: ":29138",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create proxy: %v", err)
+	}
+	proxy.AddAddon(&testWebSocketModifyAddon{})
+	go proxy.Start()
+	defer proxy.Close()
+	time.Sleep(time.Millisecond * 100)
+
+	proxyURL, _ := url.Parse("http://127.0.0.1:29138")
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyURL(proxyURL),
+		HandshakeTimeout: time.Second * 5,
+	}
+	conn, resp, err := dialer.Dial("ws://"+wsURL.Host+"/ws", nil)
+	if err != nil {
+		t.Fatalf("Failed to dial WS via proxy: %v, response: %v", err, resp)
+	}
+	defer conn.Close()
+
+	for _, msg := range []string{"drop", "inject", "hello"} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	for _, expected := range []string{"welcome", "injected", "modified hello"} {
+		_, received, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Failed to read message: %v", err)
+		}
+		if string(received) != expected {
+			t.Fatalf("Expected message %q, got %q", expected, string(received))
+		}
+	}
+}
+
+// testWebSocketDirectionAddon 按方向给消息加前缀
+type testWebSocketDirectionAddon struct {
+	BaseAddon
+}
+
+func (addon *testWebSocketDirectionAddon) WebSocketMessage(f *Flow) {
+	time.Sleep(time.Microsecond * 100) // 让另一方向的消息有机会在此期间到达
+	msg := f.WebScoket.Messages[len(f.WebScoket.Messages)-1]
+	if msg.Type != websocket.TextMessage {
+		return
+	}
+	if msg.FromClient {
+		msg.Content = append([]byte("C>"), msg.Content...)
+	} else {
+		msg.Content = append([]byte("S>"), msg.Content...)
+	}
+}
+
+// TestWebSocketConcurrentMessages 测试两个方向同时有消息时，addon 修改的是正在转发的消息
+func TestWebSocketConcurrentMessages(t *testing.T) {
+	wsServer := testWebSocketServer(t, testEchoWebSocketHandler(t))
+	defer wsServer.Close()
+	wsURL, err := url.Parse(wsServer.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse WS server URL: %v", err)
+	}
+
+	proxy, err := NewProxy(&Options{
+		Addr: "127.0.0.1:29144",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create proxy: %v", err)
+	}
+	proxy.AddAddon(&testWebSocketDirectionAddon{})
+	go proxy.Start()
+	defer proxy.Close()
+	time.Sleep(time.Millisecond * 100)
+
+	proxyURL, _ := url.Parse("http://127.0.0.1:29144")
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyURL(proxyURL),
+		HandshakeTimeout: time.Second * 5,
+	}
+	conn, resp, err := dialer.Dial("ws://"+wsURL.Host+"/ws", nil)
+	if err != nil {
+		t.Fatalf("Failed to dial WS via proxy: %v, response: %v", err, resp)
+	}
+	defer conn.Close()
+
+	// 客户端持续发送的同时，服务器回显的消息反向转发
+	const count = 500
+	go func() {
+		for i := 0; i < count; i++ {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(strconv.Itoa(i))); err != nil {
+				t.Logf("Failed to send message: %v", err)
+				return
+			}
+		}
+	}()
+	conn.SetReadDeadline(time.Now().Add(time.Second * 10))
+	for i := 0; i < count; i++ {
+		_, received, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Failed to read message: %v", err)
+		}
+		if expected := "S>C>" + strconv.Itoa(i); string(received) != expected {
+			t.Fatalf("Expected message %q, got %q", expected, string(received))
+		}
+	}
+}
+
+// testWebSocketHandshakeAddon 记录握手响应
+type testWebSocketHandshakeAddon struct {
+	BaseAddon
+	statusCodes chan int
+}
+
+func (addon *testWebSocketHandshakeAddon) Responseheaders(f *Flow) {
+	if f.Request.Method == "GET" {
+		addon.statusCodes <- f.Response.StatusCode
+	}
+}
+
+// TestWebSocketHandshake 测试握手请求头、子协议和握手响应的转发
+func TestWebSocketHandshake(t *testing.T) {
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{"graphql-ws"},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "abc" {
+			http.Error(w, "no session", http.StatusForbidden)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, http.Header{"Set-Cookie": {"seen=1"}})
+		if err != nil {
+			return
+		}
+		testEchoWebSocketHandler(t)(conn)
+	})
+
+	for _, c := range []struct {
+		name   string
+		scheme string
+		server *httptest.Server
+		addr   string
+	}{
+		{"ws", "ws", httptest.NewServer(mux), "127.0.0.1:29139"},
+		{"wss", "wss", httptest.NewTLSServer(mux), "127.0.0.1:29140"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			defer c.server.Close()
+			proxy, err := NewProxy(&Options{
+				Addr:        c.addr,
+				SslInsecure: true,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create proxy: %v", err)
+			}
+			addon := &testWebSocketHandshakeAddon{statusCodes: make(chan int, 10)}
+			proxy.AddAddon(addon)
+			go proxy.Start()
+			defer proxy.Close()
+			time.Sleep(time.Millisecond * 100)
+
+			proxyURL, _ := url.Parse("http://" + c.addr)
+			dialer := &websocket.Dialer{
+				Proxy:            http.ProxyURL(proxyURL),
+				TLSClientConfig:  &tls.Config{InsecureSkipVerify: true},
+				HandshakeTimeout: time.Second * 5,
+				Subprotocols:     []string{"mqtt", "graphql-ws"},
+			}
+			endpoint := c.scheme + "://" + c.server.Listener.Addr().String() + "/ws"
+
+			_, resp, err := dialer.Dial(endpoint, nil)
+			if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+				t.Fatalf("Expected rejected handshake, got %v %v", err, resp)
+			}
+			if code := <-addon.statusCodes; code != http.StatusForbidden {
+				t.Fatalf("Expected Responseheaders with 403, got %v", code)
+			}
+
+			conn, resp, err := dialer.Dial(endpoint, http.Header{"Cookie": {"session=abc"}})
+			if err != nil {
+				t.Fatalf("Failed to dial via proxy: %v, response: %v", err, resp)
+			}
+			defer conn.Close()
+			if conn.Subprotocol() != "graphql-ws" {
+				t.Fatalf("Expected subprotocol graphql-ws, got %q", conn.Subprotocol())
+			}
+			if resp.Header.Get("Set-Cookie") != "seen=1" {
+				t.Fatalf("Expected Set-Cookie of server, got %v", resp.Header)
+			}
+			if code := <-addon.statusCodes; code != http.StatusSwitchingProtocols {
+				t.Fatalf("Expected Responseheaders with 101, got %v", code)
+			}
+		})
+	}
+}
+
+// testWebSocketEndAddon 记录结束的 WebSocket flow
+type testWebSocketEndAddon struct {
+	BaseAddon
+	flows chan *Flow
+}
+
+func (addon *testWebSocketEndAddon) WebSocketEnd(f *Flow) {
+	addon.flows <- f
+}
+
+// TestWebSocketControlFrames 测试 ping、pong 和 close 帧的记录和转发
+func TestWebSocketControlFrames(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		testEchoWebSocketHandler(t)(conn)
+	})
+	mux.HandleFunc("/close", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4002, "server bye"))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	proxy, err := NewProxy(&Options{
+		Addr: "127.0.0.1:29141",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create proxy: %v", err)
+	}
+	addon := &testWebSocketEndAddon{flows: make(chan *Flow, 10)}
+	proxy.AddAddon(addon)
+	go proxy.Start()
+	defer proxy.Close()
+	time.Sleep(time.Millisecond * 100)
+
+	proxyURL, _ := url.Parse("http://127.0.0.1:29141")
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyURL(proxyURL),
+		HandshakeTimeout: time.Second * 5,
+	}
+
+	t.Run("closed by client", func(t *testing.T) {
+		conn, _, err := dialer.Dial("ws://"+server.Listener.Addr().String()+"/ws", nil)
+		if err != nil {
+			t.Fatalf("Failed to dial WS via proxy: %v", err)
+		}
+		defer conn.Close()
+
+		pong := make(chan string, 1)
+		conn.SetPongHandler(func(data string) error {
+			pong <- data
+			return nil
+		})
+		if err := conn.WriteControl(websocket.PingMessage, []byte("hi"), time.Now().Add(time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(4001, "bye"), time.Now().Add(time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		_, _, err = conn.ReadMessage()
+		if !websocket.IsCloseError(err, 4001) {
+			t.Fatalf("Expected close frame of server with code 4001, got %v", err)
+		}
+		if data := <-pong; data != "hi" {
+			t.Fatalf("Expected pong hi, got %q", data)
+		}
+
+		f := <-addon.flows
+		if f.WebScoket.CloseCode != 4001 || f.WebScoket.CloseReason != "bye" || f.WebScoket.ClosedBy != "client" {
+			t.Fatalf("Unexpected close %v %q %v", f.WebScoket.CloseCode, f.WebScoket.CloseReason, f.WebScoket.ClosedBy)
+		}
+		// 两个方向的帧顺序不确定
+		type frame struct {
+			typ        int
+			fromClient bool
+		}
+		frames := make(map[frame]int)
+		for _, msg := range f.WebScoket.Messages {
+			frames[frame{msg.Type, msg.FromClient}]++
+		}
+		expected := map[frame]int{
+			{websocket.PingMessage, true}:   1,
+			{websocket.PongMessage, false}:  1,
+			{websocket.CloseMessage, true}:  1,
+			{websocket.CloseMessage, false}: 1,
+		}
+		if len(frames) != len(expected) {
+			t.Fatalf("Expected frames %v, got %v", expected, frames)
+		}
+		for k, v := range expected {
+			if frames[k] != v {
+				t.Fatalf("Expected frames %v, got %v", expected, frames)
+			}
+		}
+	})
+
+	t.Run("closed by server", func(t *testing.T) {
+		conn, _, err := dialer.Dial("ws://"+server.Listener.Addr().String()+"/close", nil)
+		if err != nil {
+			t.Fatalf("Failed to dial WS via proxy: %v", err)
+		}
+		defer conn.Close()
+
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		_, _, err = conn.ReadMessage()
+		if !websocket.IsCloseError(err, 4002) {
+			t.Fatalf("Expected close frame with code 4002, got %v", err)
+		}
+
+		f := <-addon.flows
+		if f.WebScoket.CloseCode != 4002 || f.WebScoket.CloseReason != "server bye" || f.WebScoket.ClosedBy != "server" {
+			t.Fatalf("Unexpected close %v %q %v", f.WebScoket.CloseCode, f.WebScoket.CloseReason, f.WebScoket.ClosedBy)
+		}
+		if code, reason := f.WebScoket.Messages[0].Close(); code != 4002 || reason != "server bye" {
+			t.Fatalf("Unexpected close frame %v %q", code, reason)
+		}
+	})
+}
@@ -7,6 +7,7 @@ import GitHubLogo from './github-mark.svg'
 import Badge from 'react-bootstrap/Badge'
 
 import BreakPoint from './containers/BreakPoint'
+import Passthrough from './containers/Passthrough'
 import FlowPreview from './containers/FlowPreview'
 import ViewFlow from './containers/ViewFlow'
 import Resizer from './components/Resizer'
@@ -208,6 +209,14 @@ class App extends React.Component<IProps, IState> {
         // WebSocket 连接结束
         const wsEnd = msg.content as IWebSocketEnd
         console.log('[WebSocket End]', { id: msg.id, connId: wsEnd.connId, messageCount: wsEnd.messageCount })
+        const flow = this.flowMgr.get(msg.id)
+        if (flow) flow.setWebSocketEnd(msg)
+        this.setState({ flows: this.state.flows })
+      }
+      else if (msg.type === MessageType.ERROR) {
+        const flow = this.flowMgr.get(msg.id)
+        if (!flow) return
+        flow.addError(msg)
         this.setState({ flows: this.state.flows })
       }
       else if (msg.type === MessageType.SSE_START) {
@@ -314,6 +323,10 @@ class App extends React.Component<IProps, IState> {
                 this.wsSend(msg)
               }} />
             </div>
+
+            <div style={{ marginRight: '10px' }}>
+              <Passthrough />
+            </div>
           </div>
 
           <div style={{ display: 'flex', alignItems: 'center' }}>
@@ -1,4 +1,5 @@
 import React from 'react'
+import Badge from 'react-bootstrap/Badge'
 import { shallowEqual } from '../utils/utils'
 import type { IFlowPreview } from '../utils/flow'
 
@@ -37,7 +38,7 @@ class FlowPreview extends React.Component<IProps> {
         <td>{fp.no}</td>
         <td>{fp.method}</td>
         <td>{fp.host}</td>
-        <td>{fp.path}</td>
+        <td>{fp.isReplay ? <Badge bg="info" style={{ marginRight: '4px' }}>Replay</Badge> : null}{fp.path}</td>
         <td>{fp.contentType}</td>
         <td>{fp.statusCode}</td>
         <td>{fp.size}</td>
@@ -0,0 +1,83 @@
+import React, { useState } from 'react'
+import Button from 'react-bootstrap/Button'
+import Modal from 'react-bootstrap/Modal'
+import Form from 'react-bootstrap/Form'
+import Table from 'react-bootstrap/Table'
+
+// 查看和编辑 TLS passthrough 的域名，包括因客户端拒绝证书而自动添加的域名
+function Passthrough() {
+  const [show, setShow] = useState(false)
+  const [hosts, setHosts] = useState<string[]>([])
+  const [newHost, setNewHost] = useState('')
+  const [error, setError] = useState('')
+
+  const request = async (method: string, host?: string) => {
+    const query = host ? `?host=${encodeURIComponent(host)}` : ''
+    try {
+      const res = await fetch(`/api/passthrough${query}`, { method })
+      if (!res.ok) {
+        setError(await res.text())
+        return
+      }
+      const data = await res.json()
+      setHosts(data.hosts || [])
+      setError('')
+    } catch (err: any) {
+      setError(String(err))
+    }
+  }
+
+  const handleClose = () => setShow(false)
+  const handleShow = () => {
+    setShow(true)
+    request('GET')
+  }
+
+  return (
+    <div>
+      <Button variant="primary" size="sm" onClick={handleShow}>Passthrough</Button>
+
+      <Modal show={show} onHide={handleClose}>
+        <Modal.Header closeButton>
+          <Modal.Title>TLS Passthrough</Modal.Title>
+        </Modal.Header>
+
+        <Modal.Body>
+          {error ? <div style={{ color: 'red', marginBottom: '10px' }}>{error}</div> : null}
+
+          <Table striped bordered size="sm">
+            <tbody>
+              {
+                hosts.length === 0 ? <tr><td style={{ color: 'gray' }}>No hosts</td></tr> :
+                  hosts.map(host => (
+                    <tr key={host}>
+                      <td>{host}</td>
+                      <td style={{ width: '80px' }}>
+                        <Button variant="danger" size="sm" onClick={() => { request('DELETE', host) }}>Remove</Button>
+                      </td>
+                    </tr>
+                  ))
+              }
+            </tbody>
+          </Table>
+
+          <div style={{ display: 'flex', gap: '10px' }}>
+            <Form.Control size="sm" placeholder="example.com" value={newHost} onChange={e => { setNewHost(e.target.value) }} />
+            <Button variant="primary" size="sm" disabled={!newHost} onClick={() => {
+              request('POST', newHost)
+              setNewHost('')
+            }}>Add</Button>
+          </div>
+        </Modal.Body>
+
+        <Modal.Footer>
+          <Button variant="secondary" onClick={handleClose}>
+            Close
+          </Button>
+        </Modal.Footer>
+      </Modal>
+    </div>
+  )
+}
+
+export default Passthrough
@@ -152,50 +152,65 @@ function ViewFlow({ flow, onClose, onReRenderFlows, onMessage }: Iprops) {
       }
     }
 
+    const wsEnd = flow.webSocketEnd
+
     return (
-      <Table striped bordered hover size="sm">
-        <thead>
-          <tr>
-            <th style={{ width: '60px' }}>Index</th>
-            <th style={{ width: '80px' }}>Direction</th>
-            <th style={{ width: '60px' }}>Type</th>
-            <th>Content</th>
-            <th style={{ width: '180px' }}>Time</th>
-          </tr>
-        </thead>
-        <tbody>
-          {flow.webSocketMessages.map((msg, index) => (
-            <tr key={index}>
-              <td>{index}</td>
-              <td>
-                {msg.fromClient ?
-                  <Badge bg="primary">C → S</Badge> :
-                  <Badge bg="success">S → C</Badge>
-                }
-              </td>
-              <td>
-                {msg.type === 1 ?
-                  <Badge bg="info">Text</Badge> :
-                  <Badge bg="secondary">Binary</Badge>
-                }
-              </td>
-              <td>
-                <div style={{
-                  maxWidth: '500px',
-                  wordBreak: 'break-all',
-                  whiteSpace: 'pre-wrap',
-                  fontSize: '12px'
-                }}>
-                  {decodeContent(msg.content)}
-                </div>
-              </td>
-              <td style={{ fontSize: '11px', color: '#666' }}>
-                {new Date(msg.timestamp).toLocaleTimeString()}
-              </td>
+      <div>
+        {
+          wsEnd?.closedBy ?
+            <div style={{ marginBottom: '10px' }}>
+              Closed by {wsEnd.closedBy}: {wsEnd.closeCode} {wsEnd.closeReason}
+            </div> : null
+        }
+        <Table striped bordered hover size="sm">
+          <thead>
+            <tr>
+              <th style={{ width: '60px' }}>Index</th>
+              <th style={{ width: '80px' }}>Direction</th>
+              <th style={{ width: '60px' }}>Type</th>
+              <th>Content</th>
+              <th style={{ width: '180px' }}>Time</th>
             </tr>
-          ))}
-        </tbody>
-      </Table>
+          </thead>
+          <tbody>
+            {flow.webSocketMessages.map((msg, index) => (
+              <tr key={index}>
+                <td>{index}</td>
+                <td>
+                  {msg.fromClient ?
+                    <Badge bg="primary">C → S</Badge> :
+                    <Badge bg="success">S → C</Badge>
+                  }
+                  {msg.injected ? <Badge bg="warning" style={{ marginLeft: '3px' }}>Injected</Badge> : null}
+                  {msg.dropped ? <Badge bg="danger" style={{ marginLeft: '3px' }}>Dropped</Badge> : null}
+                </td>
+                <td>
+                  {
+                    msg.type === 1 ? <Badge bg="info">Text</Badge> :
+                      msg.type === 2 ? <Badge bg="secondary">Binary</Badge> :
+                        msg.type === 8 ? <Badge bg="dark">Close</Badge> :
+                          msg.type === 9 ? <Badge bg="light" text="dark">Ping</Badge> :
+                            <Badge bg="light" text="dark">Pong</Badge>
+                  }
+                </td>
+                <td>
+                  <div style={{
+                    maxWidth: '500px',
+                    wordBreak: 'break-all',
+                    whiteSpace: 'pre-wrap',
+                    fontSize: '12px'
+                  }}>
+                    {msg.type === 8 ? `${msg.closeCode} ${msg.closeReason || ''}` : decodeContent(msg.content)}
+                  </div>
+                </td>
+                <td style={{ fontSize: '11px', color: '#666' }}>
+                  {new Date(msg.timestamp).toLocaleTimeString()}
+                </td>
+              </tr>
+            ))}
+          </tbody>
+        </Table>
+      </div>
     )
   }
 
@@ -267,8 +282,51 @@ function ViewFlow({ flow, onClose, onReRenderFlows, onMessage }: Iprops) {
           <p>Flow Info</p>
           <div className="header-block-content">
             <p>Id: {flow.id}</p>
+            {flow.isReplay ? <p>Replay: true</p> : null}
           </div>
         </div>
+        {
+          !flow.timingPhases().length ? null :
+            <div className="header-block">
+              <p>Timing</p>
+              <div className="header-block-content">
+                {
+                  flow.timingPhases().map(({ name, duration }) => (
+                    <p key={name}>{name}: {duration.toFixed(1)} ms</p>
+                  ))
+                }
+              </div>
+            </div>
+        }
+        {
+          !flow.error ? null :
+            <div className="header-block">
+              <p>Error</p>
+              <div className="header-block-content">
+                <p>{flow.error.error}</p>
+                {
+                  !flow.error.certVerifyError ? null :
+                    <>
+                      <p>Rejected Host: {flow.error.certVerifyError.host}</p>
+                      <p>Reason: {flow.error.certVerifyError.reason}</p>
+                      {
+                        flow.error.certVerifyError.chain.map((c, index) => (
+                          <div key={c.fingerprint + index} style={{ marginTop: '10px' }}>
+                            <p>Certificate #{index}</p>
+                            <p>Subject: {c.subject}</p>
+                            <p>Issuer: {c.issuer}</p>
+                            {c.dnsNames ? <p>DNS Names: {c.dnsNames.join(', ')}</p> : null}
+                            <p>Validity: {c.notBefore} ~ {c.notAfter}</p>
+                            <p>SHA256 Fingerprint: {c.fingerprint}</p>
+                            <p>Pin: {c.pin}</p>
+                          </div>
+                        ))
+                      }
+                    </>
+                }
+              </div>
+            </div>
+        }
         {
           !conn ? null :
             <>
@@ -280,6 +338,7 @@ function ViewFlow({ flow, onClose, onReRenderFlows, onMessage }: Iprops) {
                       <div className="header-block-content">
                         <p>Address: {conn.serverConn.address}</p>
                         <p>Resolved Address: {conn.serverConn.peername}</p>
+                        {conn.serverConn.ja3s ? <p>JA3S: {conn.serverConn.ja3s}</p> : null}
                       </div>
                     </div>
                   </>
@@ -288,6 +347,8 @@ function ViewFlow({ flow, onClose, onReRenderFlows, onMessage }: Iprops) {
                 <p>Client Connection</p>
                 <div className="header-block-content">
                   <p>Address: {conn.clientConn.address}</p>
+                  {conn.clientConn.ja3 ? <p>JA3: {conn.clientConn.ja3}</p> : null}
+                  {conn.clientConn.ja4 ? <p>JA4: {conn.clientConn.ja4}</p> : null}
                 </div>
               </div>
               <div className="header-block">
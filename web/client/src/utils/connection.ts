@@ -3,11 +3,15 @@ export interface IConnection {
     id: string
     tls: boolean
     address: string
+    peerCertificates?: string[]
+    ja3?: string
+    ja4?: string
   }
   serverConn?: {
     id: string
     address: string
     peername: string
+    ja3s?: string
   }
   intercept: boolean
   opening?: boolean
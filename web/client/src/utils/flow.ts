@@ -1,5 +1,5 @@
 import type { ConnectionManager, IConnection } from './connection'
-import { IMessage, MessageType, IWebSocketMessage, IWebSocketMessageData, ISSEEvent, ISSEMessageData } from './message'
+import { IMessage, MessageType, IWebSocketMessage, IWebSocketMessageData, IWebSocketEnd, ISSEEvent, ISSEMessageData, IFlowError } from './message'
 import { arrayBufferToBase64, bufHexView, getHeader, getSize, hasHeader, isTextBody } from './utils'
 import { FlowFilter } from './filter'
 
@@ -16,12 +16,26 @@ export interface IRequest {
 export interface IFlowRequest {
   connId: string
   request: IRequest
+  isReplay?: boolean
 }
 
 export interface IResponse {
   statusCode: number
   header: Header
   body?: ArrayBuffer
+  timing?: ITiming
+}
+
+// unix 毫秒时间戳，0 表示未发生
+export interface ITiming {
+  clientConnect: number
+  requestHeaders: number
+  dnsDone: number
+  connectDone: number
+  tlsHandshakeDone: number
+  requestSent: number
+  firstResponseByte: number
+  responseComplete: number
 }
 
 export interface IPreviewBody {
@@ -41,6 +55,7 @@ export interface IFlowPreview {
   costTime: string
   contentType: string
   warn: boolean
+  isReplay: boolean
 }
 
 export class Flow {
@@ -48,8 +63,10 @@ export class Flow {
   public id: string
   public connId!: string
   public waitIntercept!: boolean
+  public isReplay = false // 由 Proxy.Replay 重放
   public request!: IRequest
   public response: IResponse | null = null
+  public error: IFlowError | null = null
 
   public url!: URL
   private path!: string
@@ -79,6 +96,7 @@ export class Flow {
   // WebSocket 相关字段
   public webSocketMessages: IWebSocketMessage[] = []
   public isWebSocket = false
+  public webSocketEnd: IWebSocketEnd | null = null
 
   // SSE 相关字段
   public sseEvents: ISSEEvent[] = []
@@ -108,6 +126,7 @@ export class Flow {
     const flowRequestMsg = msg.content as IFlowRequest
     this.connId = flowRequestMsg.connId
     this.request = flowRequestMsg.request
+    this.isReplay = !!flowRequestMsg.isReplay
 
     let rawUrl = this.request.url
     if (rawUrl.startsWith('//')) rawUrl = 'http:' + rawUrl
@@ -187,6 +206,13 @@ export class Flow {
     return this
   }
 
+  public addError(msg: IMessage): Flow {
+    this.error = msg.content as IFlowError
+    this.endTime = Date.now()
+    this.costTime = String(this.endTime - this.startTime) + ' ms'
+    return this
+  }
+
   public preview(): IFlowPreview {
     return {
       no: this.no,
@@ -195,14 +221,35 @@ export class Flow {
       host: this.url.host,
       path: this.path,
       method: this.request.method,
-      statusCode: this.response ? String(this.response.statusCode) : '(pending)',
+      statusCode: this.response ? String(this.response.statusCode) : (this.error ? '(failed)' : '(pending)'),
       size: this.size,
       costTime: this.costTime,
       contentType: this.contentType,
       warn: this.getConn()?.flowCount === 0,
+      isReplay: this.isReplay,
     }
   }
 
+  // 收到请求头之后各阶段的耗时，复用的连接没有 dns、connect、tls 阶段
+  public timingPhases(): Array<{ name: string; duration: number }> {
+    const timing = this.response?.timing
+    if (!timing || !timing.requestHeaders) return []
+    const phases: Array<{ name: string; duration: number }> = []
+    let last = timing.requestHeaders
+    const add = (name: string, t: number) => {
+      if (!t || t < last) return
+      phases.push({ name, duration: t - last })
+      last = t
+    }
+    add('DNS', timing.dnsDone)
+    add('Connect', timing.connectDone)
+    add('TLS', timing.tlsHandshakeDone)
+    add('Send', timing.requestSent)
+    add('Wait', timing.firstResponseByte)
+    add('Receive', timing.responseComplete)
+    return phases
+  }
+
   public isTextRequest(): boolean {
     if (this._isTextRequest !== null) return this._isTextRequest
     this._isTextRequest = isTextBody(this.request)
@@ -323,6 +370,11 @@ export class Flow {
     return this
   }
 
+  public setWebSocketEnd(msg: IMessage): Flow {
+    this.webSocketEnd = msg.content as IWebSocketEnd
+    return this
+  }
+
   // SSE 相关方法
   public addSSEMessage(msg: IMessage): Flow {
     const sseMsgData = msg.content as ISSEMessageData
@@ -14,6 +14,7 @@ export enum MessageType {
   WEBSOCKET_START = 6,
   WEBSOCKET_MESSAGE = 7,
   WEBSOCKET_END = 8,
+  ERROR = 9,
   SSE_START = 30,
   SSE_MESSAGE = 31,
   SSE_END = 32,
@@ -29,6 +30,7 @@ const allMessageBytes = [
   MessageType.WEBSOCKET_START,
   MessageType.WEBSOCKET_MESSAGE,
   MessageType.WEBSOCKET_END,
+  MessageType.ERROR,
   MessageType.SSE_START,
   MessageType.SSE_MESSAGE,
   MessageType.SSE_END,
@@ -36,10 +38,14 @@ const allMessageBytes = [
 
 // WebSocket 消息结构
 export interface IWebSocketMessage {
-  type: number       // 1=Text, 2=Binary
+  type: number       // 1=Text, 2=Binary, 8=Close, 9=Ping, 10=Pong
   content: string     // base64 编码的内容
   fromClient: boolean
   timestamp: string   // ISO 8601 格式时间戳
+  dropped?: boolean   // addon 丢弃，未转发
+  injected?: boolean  // addon 注入
+  closeCode?: number  // close 帧的状态码
+  closeReason?: string
 }
 
 // WebSocket Start 消息内容
@@ -59,6 +65,9 @@ export interface IWebSocketMessageData {
 export interface IWebSocketEnd {
   connId: string
   messageCount: number
+  closeCode?: number
+  closeReason?: string
+  closedBy?: string  // client 或 server，先关闭的一方
 }
 
 // SSE 事件结构
@@ -90,16 +99,40 @@ export interface ISSEEnd {
   eventCount: number
 }
 
+// 上游证书校验失败
+export interface ICertVerifyError {
+  host: string
+  reason: string
+  error: string
+  chain: Array<{
+    subject: string
+    issuer: string
+    dnsNames: string[] | null
+    notBefore: string
+    notAfter: string
+    fingerprint: string
+    pin: string
+    pem: string
+  }>
+}
+
+// Error 消息内容
+export interface IFlowError {
+  connId: string
+  error: string
+  certVerifyError?: ICertVerifyError
+}
+
 export interface IMessage {
   type: MessageType
   id: string
   waitIntercept: boolean
   content?: ArrayBuffer | IFlowRequest | IResponse | IConnection | number |
     IWebSocketStart | IWebSocketMessageData | IWebSocketEnd |
-    ISSEStart | ISSEMessageData | ISSEEnd
+    ISSEStart | ISSEMessageData | ISSEEnd | IFlowError
 }
 
-// type: 0/1/2/3/4
+// type: 0/1/2/3/4/5/9
 // messageFlow
 // version 1 byte + type 1 byte + id 36 byte + waitIntercept 1 byte + content left bytes
 export const parseMessage = (data: ArrayBuffer): IMessage | null => {
@@ -13,7 +13,7 @@ import (
 
 // message:
 
-// type: 0/1/2/3/4/5
+// type: 0/1/2/3/4/5/9
 // messageFlow
 // version 1 byte + type 1 byte + id 36 byte + waitIntercept 1 byte + content left bytes
 
@@ -39,6 +39,7 @@ const (
 	messageTypeWebSocketStart  messageType = 6
 	messageTypeWebSocketMessage messageType = 7
 	messageTypeWebSocketEnd    messageType = 8
+	messageTypeError           messageType = 9
 
 	messageTypeChangeRequest  messageType = 11
 	messageTypeChangeResponse messageType = 12
@@ -62,6 +63,7 @@ var allMessageTypes = []messageType{
 	messageTypeWebSocketStart,
 	messageTypeWebSocketMessage,
 	messageTypeWebSocketEnd,
+	messageTypeError,
 	messageTypeSSEStart,
 	messageTypeSSEMessage,
 	messageTypeSSEEnd,
@@ -105,6 +107,7 @@ func newMessageFlow(mType messageType, f *proxy.Flow) (*messageFlow, error) {
 		m := make(map[string]interface{})
 		m["request"] = f.Request
 		m["connId"] = f.ConnContext.Id().String()
+		m["isReplay"] = f.IsReplay
 		content, err = json.Marshal(m)
 	case messageTypeRequestBody:
 		content, err = f.Request.DecodedBody()
@@ -113,7 +116,10 @@ func newMessageFlow(mType messageType, f *proxy.Flow) (*messageFlow, error) {
 			err = errors.New("no response")
 			break
 		}
-		content, err = json.Marshal(f.Response)
+		content, err = json.Marshal(struct {
+			*proxy.Response
+			Timing *proxy.Timing `json:"timing"`
+		}{f.Response, f.Timing})
 	case messageTypeResponseBody:
 		if f.Response == nil {
 			err = errors.New("no response")
@@ -143,6 +149,9 @@ func newMessageFlow(mType messageType, f *proxy.Flow) (*messageFlow, error) {
 		m["connId"] = f.ConnContext.Id().String()
 		if f.WebScoket != nil {
 			m["messageCount"] = len(f.WebScoket.Messages)
+			m["closeCode"] = f.WebScoket.CloseCode
+			m["closeReason"] = f.WebScoket.CloseReason
+			m["closedBy"] = f.WebScoket.ClosedBy
 		}
 		content, err = json.Marshal(m)
 	case messageTypeSSEStart:
@@ -185,6 +194,26 @@ func newMessageFlow(mType messageType, f *proxy.Flow) (*messageFlow, error) {
 	}, nil
 }
 
+// newMessageFlowError RequestError or HTTPConnectError, certVerifyError is set if the upstream certificate is rejected
+func newMessageFlowError(f *proxy.Flow, err error) (*messageFlow, error) {
+	m := make(map[string]interface{})
+	m["connId"] = f.ConnContext.Id().String()
+	m["error"] = err.Error()
+	var certErr *proxy.CertVerifyError
+	if errors.As(err, &certErr) {
+		m["certVerifyError"] = certErr
+	}
+	content, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return &messageFlow{
+		mType:   messageTypeError,
+		id:      f.Id,
+		content: content,
+	}, nil
+}
+
 func newMessageConnClose(connCtx *proxy.ConnContext) *messageFlow {
 	var buf bytes.Buffer
 	binary.Write(&buf, binary.BigEndian, connCtx.FlowCount.Load())
@@ -2,9 +2,11 @@ package web
 
 import (
 	"embed"
+	"encoding/json"
 	"fmt"
 	"io/fs"
 	"net/http"
+	"net/url"
 	"sync"
 
 	"github.com/gorilla/websocket"
@@ -26,6 +28,9 @@ type WebAddon struct {
 
 	flowMessageState map[*proxy.Flow]messageType
 	flowMu           sync.Mutex
+
+	passthrough *proxy.TlsPassthrough
+	proxy       *proxy.Proxy
 }
 
 func NewWebAddon(addr string) *WebAddon {
@@ -41,6 +46,8 @@ func NewWebAddon(addr string) *WebAddon {
 
 	serverMux := new(http.ServeMux)
 	serverMux.HandleFunc("/echo", web.echo)
+	serverMux.HandleFunc("/api/passthrough", web.handlePassthrough)
+	serverMux.HandleFunc("/api/replay", web.handleReplay)
 
 	fsys, err := fs.Sub(assets, "client/build")
 	if err != nil {
@@ -77,6 +84,107 @@ func (web *WebAddon) echo(w http.ResponseWriter, r *http.Request) {
 	conn.readloop()
 }
 
+// SetTlsPassthrough 在 web 界面中查看和编辑 passthrough 的域名
+func (web *WebAddon) SetTlsPassthrough(tp *proxy.TlsPassthrough) {
+	web.passthrough = tp
+}
+
+// handlePassthrough GET 返回 passthrough 域名列表，POST 添加 host，DELETE 删除 host
+func (web *WebAddon) handlePassthrough(w http.ResponseWriter, r *http.Request) {
+	if web.passthrough == nil {
+		http.Error(w, "tls passthrough is not enabled", http.StatusNotFound)
+		return
+	}
+
+	host := r.FormValue("host")
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if host == "" {
+			http.Error(w, "empty host", http.StatusBadRequest)
+			return
+		}
+		err = web.passthrough.Add(host)
+	case http.MethodDelete:
+		if host == "" {
+			http.Error(w, "empty host", http.StatusBadRequest)
+			return
+		}
+		err = web.passthrough.Remove(host)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"hosts": web.passthrough.Hosts(),
+	})
+}
+
+// SetProxy 通过 /api/replay 在运行中的 proxy 重放请求
+func (web *WebAddon) SetProxy(p *proxy.Proxy) {
+	web.proxy = p
+}
+
+// ReplayRequest /api/replay 的请求体
+type ReplayRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Proto  string      `json:"proto"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"` // base64 encoded
+}
+
+// handleReplay POST 重放请求，返回 flow id 和响应状态码
+func (web *WebAddon) handleReplay(w http.ResponseWriter, r *http.Request) {
+	if web.proxy == nil {
+		http.Error(w, "replay is not enabled", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := new(ReplayRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f, err := web.proxy.Replay(&proxy.Request{
+		Method: req.Method,
+		URL:    u,
+		Proto:  req.Proto,
+		Header: req.Header,
+		Body:   req.Body,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	statusCode := 0
+	if f.Response != nil {
+		statusCode = f.Response.StatusCode
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":         f.Id,
+		"statusCode": statusCode,
+	})
+}
+
 func (web *WebAddon) addConn(c *concurrentConn) {
 	web.connsMu.Lock()
 	web.conns = append(web.conns, c)
@@ -243,11 +351,26 @@ func (web *WebAddon) SSEEnd(f *proxy.Flow) {
 }
 
 func (web *WebAddon) RequestError(f *proxy.Flow, err error) {
+	web.sendMessageUntil(f, messageTypeRequestBody)
+	web.sendFlow(func() (*messageFlow, error) {
+		return newMessageFlowError(f, err)
+	})
+
 	web.flowMu.Lock()
 	delete(web.flowMessageState, f)
 	web.flowMu.Unlock()
 }
 
+// HTTPConnectError 发送 CONNECT 失败的消息，如上游证书校验失败
+func (web *WebAddon) HTTPConnectError(f *proxy.Flow, err error) {
+	web.sendFlow(func() (*messageFlow, error) {
+		return newMessageFlow(messageTypeRequest, f)
+	})
+	web.sendFlow(func() (*messageFlow, error) {
+		return newMessageFlowError(f, err)
+	})
+}
+
 func (web *WebAddon) isIntercpt(f *proxy.Flow, mType messageType) bool {
 	web.connsMu.RLock()
 	conns := web.conns